@@ -87,6 +87,19 @@ func (s *DatastoreSessionStore) Save(r *http.Request, w http.ResponseWriter,
 	return sessions.SetCookie(s, w, key, cloneInfo(info, sid))
 }
 
+// Delete removes the session entity for the given key from the datastore.
+func (s *DatastoreSessionStore) Delete(r *http.Request, key string) error {
+	if sid, ok := sessionId(s, r, key); ok {
+		c := appengine.NewContext(r)
+		entityKey := datastore.NewKey(c, "Session", sessionKey(sid), 0, nil)
+		err := datastore.Delete(c, entityKey)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+	}
+	return nil
+}
+
 // ----------------------------------------------------------------------------
 // MemcacheSessionStore
 // ----------------------------------------------------------------------------
@@ -136,10 +149,31 @@ func (s *MemcacheSessionStore) Save(r *http.Request, w http.ResponseWriter,
 	return sessions.SetCookie(s, w, key, cloneInfo(info, sid))
 }
 
+// Delete removes the session item for the given key from the memcache.
+func (s *MemcacheSessionStore) Delete(r *http.Request, key string) error {
+	if sid, ok := sessionId(s, r, key); ok {
+		err := memcache.Delete(appengine.NewContext(r), sessionKey(sid))
+		if err != nil && err != memcache.ErrCacheMiss {
+			return err
+		}
+	}
+	return nil
+}
+
 func sessionKey(sid string) string {
 	return fmt.Sprintf("gorilla.appengine.sessions.%s", sid)
 }
 
+// sessionId returns the session id stored in the session cookie, if any.
+func sessionId(s sessions.SessionStore, r *http.Request, key string) (string, bool) {
+	if sidval, ok := sessions.GetCookie(s, r, key)["sid"]; ok {
+		if sid, ok := sidval.(string); ok {
+			return sid, true
+		}
+	}
+	return "", false
+}
+
 // Create a new sid and serialize data.
 func getIdAndData(info *sessions.SessionInfo) (sid string, serialized []byte, err error) {
 	// Create a new session id.
@@ -26,14 +26,14 @@ type namespaceMap map[Namespacer]interface{}
 
 // Context stores values for requests.
 type Context struct {
-	lk sync.Mutex
+	lk sync.RWMutex
 	m  contextMap
 }
 
 // Get returns the value for a given namespace in a given request.
 func (c *Context) Get(req *http.Request, ns Namespacer) interface{} {
-	c.lk.Lock()
-	defer c.lk.Unlock()
+	c.lk.RLock()
+	defer c.lk.RUnlock()
 	if c.m != nil {
 		if c.m[req] != nil {
 			return c.m[req][ns]
@@ -42,6 +42,20 @@ func (c *Context) Get(req *http.Request, ns Namespacer) interface{} {
 	return nil
 }
 
+// GetAll returns a copy of all values stored in a given request, keyed by
+// namespace.
+func (c *Context) GetAll(req *http.Request) map[interface{}]interface{} {
+	c.lk.RLock()
+	defer c.lk.RUnlock()
+	rv := make(map[interface{}]interface{})
+	if c.m != nil {
+		for ns, val := range c.m[req] {
+			rv[ns] = val
+		}
+	}
+	return rv
+}
+
 // Set stores a value for a given namespace in a given request.
 func (c *Context) Set(req *http.Request, ns Namespacer, val interface{}) {
 	c.lk.Lock()
@@ -77,6 +91,19 @@ func (c *Context) ClearNamespace(req *http.Request, ns Namespacer) {
 	}
 }
 
+// ClearHandler wraps an http.Handler and clears all values stored in the
+// default context for the request after the handler returns, even if it
+// panics.
+//
+// This is only needed when the handler is not served by gorilla/mux, which
+// already clears the default context at the end of a request.
+func ClearHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer DefaultContext.Clear(r)
+		h.ServeHTTP(w, r)
+	})
+}
+
 // ----------------------------------------------------------------------------
 // Namespace
 // ----------------------------------------------------------------------------
@@ -127,6 +154,13 @@ func (n *Namespace) Get(request *http.Request) interface{} {
 	return n.GetContext().Get(request, n)
 }
 
+// GetAll returns a copy of all values stored in the request context this
+// namespace is attached to, keyed by namespace. It is mostly useful for
+// debugging.
+func (n *Namespace) GetAll(request *http.Request) map[interface{}]interface{} {
+	return n.GetContext().GetAll(request)
+}
+
 // Set stores a value for this namespace in the request context.
 func (n *Namespace) Set(request *http.Request, val interface{}) {
 	n.GetContext().Set(request, n, val)
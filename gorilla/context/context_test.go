@@ -60,3 +60,68 @@ func TestContext(t *testing.T) {
 	assertEqual(len(DefaultContext.m), 1)
 	assertEqual(len(DefaultContext.m[req]), 0)
 }
+
+func TestGetAll(t *testing.T) {
+	c := new(Context)
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	ns1 := &Namespace{Context: c}
+	ns2 := &Namespace{Context: c}
+	ns3 := &Namespace{Context: c}
+
+	if all := ns1.GetAll(req); len(all) != 0 {
+		t.Errorf("Expected empty values, got %v.", all)
+	}
+
+	ns1.Set(req, "1")
+	ns2.Set(req, "2")
+	ns3.Set(req, "3")
+	expected := map[interface{}]interface{}{ns1: "1", ns2: "2", ns3: "3"}
+	all := ns1.GetAll(req)
+	if len(all) != len(expected) {
+		t.Errorf("Expected %v values, got %v.", len(expected), len(all))
+	}
+	for k, v := range expected {
+		if all[k] != v {
+			t.Errorf("Expected %v, got %v.", v, all[k])
+		}
+	}
+
+	// The returned map is a copy.
+	delete(all, ns1)
+	if ns1.Get(req) != "1" {
+		t.Errorf("Expected GetAll() to return a copy.")
+	}
+}
+
+func TestClearHandler(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	ns := new(Namespace)
+
+	h := ClearHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ns.Set(r, "1")
+		if ns.Get(r) != "1" {
+			t.Errorf("Expected value to be set inside the handler.")
+		}
+	}))
+	h.ServeHTTP(nil, req)
+	if DefaultContext.m[req] != nil {
+		t.Errorf("Expected cleared context, got %v.", DefaultContext.m[req])
+	}
+
+	// The context is also cleared if the handler panics.
+	h = ClearHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ns.Set(r, "2")
+		panic("handler panic")
+	}))
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("Expected the panic to propagate.")
+			}
+		}()
+		h.ServeHTTP(nil, req)
+	}()
+	if DefaultContext.m[req] != nil {
+		t.Errorf("Expected cleared context, got %v.", DefaultContext.m[req])
+	}
+}
@@ -70,6 +70,11 @@ Make sure that the main handler clears the context after serving a request:
 This calls Clear() from the Context instance, removing all namespaces
 registered for a request.
 
+Alternatively, wrap the main handler with ClearHandler(), which does the same
+for any http.Handler:
+
+	http.Handle("/", context.ClearHandler(handler))
+
 The package gorilla/mux clears the default context, so if you are using the
 default handler from there you don't need to clear anything: any namespaces
 set using the default context will be cleared at the end of a request.
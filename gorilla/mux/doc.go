@@ -54,6 +54,13 @@ calling mux.Vars():
 	vars := mux.Vars(request)
 	category := vars["category"]
 
+To also accept an optional extension naming a response format, stored in
+the "format" variable, use PathWithFormat:
+
+	// Matches "/products/42", "/products/42.json" and "/products/42.xml".
+	r.NewRoute().PathWithFormat("/products/{key}", "json", "xml").
+	  HandlerFunc(ProductHandler)
+
 And this is all you need to know about the basic usage. More advanced options
 are explained below.
 
@@ -67,10 +74,17 @@ pattern to be matched. They can also have variables:
 	r.HandleFunc("/products", ProductsHandler).
 		Host("{subdomain:[a-z]+}.domain.com")
 
+To match a fixed host, ignoring case and the port, without a template:
+
+	r.HandleFunc("/products", ProductsHandler).HostExact("www.domain.com")
+
 There are several other matchers that can be added. To match HTTP methods:
 
 	r.HandleFunc("/products", ProductsHandler).Methods("GET", "POST")
 
+The handler can retrieve the method that matched calling
+mux.MatchedMethod(request).
+
 ...or to match a given URL scheme:
 
 	r.HandleFunc("/products", ProductsHandler).Schemes("https")
@@ -80,20 +94,81 @@ There are several other matchers that can be added. To match HTTP methods:
 	r.HandleFunc("/products", ProductsHandler).
 	  Headers("X-Requested-With", "XMLHttpRequest")
 
+...or to require a header to be sent with a single, specific value:
+
+	r.HandleFunc("/products", ProductsHandler).
+	  HeadersExact("Accept", "application/json")
+
 ...or to match specific URL query values:
 
 	r.HandleFunc("/products", ProductsHandler).Queries("key", "value")
 
+...or to require URL query keys not to be set:
+
+	r.HandleFunc("/products", ProductsHandler).QueryAbsent("format")
+
+...or to require a TLS client certificate:
+
+	r.HandleFunc("/api", APIHandler).RequireClientCert()
+
+...or to match WebSocket handshake requests:
+
+	r.HandleFunc("/chat", ChatHandler).WebSocket()
+
+...or to answer requests with a body larger than a limit with a
+"413 Request Entity Too Large" response:
+
+	r.HandleFunc("/upload", UploadHandler).MaxBodySize(1 << 20)
+
 ...or to use a custom matcher function:
 
 	r.HandleFunc("/products", ProductsHandler).Matcher(MatcherFunc)
 
+...or to use a custom matcher function that also sets route variables,
+available through mux.Vars() in the handler:
+
+	r.HandleFunc("/account", AccountHandler).MatcherVars(
+	  func(req *http.Request, vars map[string]string) bool {
+	    vars["user"] = req.Header.Get("X-User")
+	    return vars["user"] != ""
+	  })
+
+...or to answer CORS preflight requests and allow cross-origin requests.
+Preflight requests bypass the Methods matchers:
+
+	r.HandleFunc("/products", ProductsHandler).Methods("GET").
+	  CORS(mux.CORSOptions{AllowOrigin: "http://www.domain.com"})
+
 ...and finally, it is possible to combine several matchers in a single route:
 
 	r.HandleFunc("/products", ProductsHandler).
 	  Host("www.domain.com").
 	  Methods("GET").Schemes("http")
 
+Clients that can only send GET and POST requests can ask for another method
+in a X-HTTP-Method-Override header or a "_method" form field, if the router
+allows it. POST requests are then matched using that method:
+
+	r.UseMethodOverride()
+
+When no route matches, the router calls its NotFoundHandler. To handle
+unmatched requests with a normal route instead, use the not found route. It is
+only tested after all other routes in the router:
+
+	r.NotFoundRoute().HandlerFunc(NotFoundHandler)
+
+Requests that a route would match with another method get a "405 Method Not
+Allowed" response instead, with an Allow header listing the methods the
+routes accept, even if a NotFoundHandler or not found route is set. Routes
+of subrouters count too. To answer such requests as not found:
+
+	r.HandleMethodNotAllowed(false)
+
+Headers that every response should carry can be set on the router. Handlers
+can still change them before writing the response:
+
+	r.DefaultHeaders("X-Content-Type-Options", "nosniff")
+
 Setting the same matching conditions again and again can be boring, so we have
 a way to group several routes that share the same requirements.
 We call it "subrouting".
@@ -116,6 +191,32 @@ The three URL paths we registered above will only be tested if the domain is
 only convenient, but also optimizes request matching. You can create
 subrouters combining any attribute matchers accepted by a route.
 
+An existing http.ServeMux can be mounted under a path prefix. The prefix is
+stripped before the ServeMux handles the request, so "/legacy/users/1" is
+handled by its "/users/" pattern:
+
+	r.NewRoute().PathPrefix("/legacy/").MountServeMux(legacyMux)
+
+Static files can be served in a similar way. The prefix is stripped and the
+rest of the path is looked up in a http.FileSystem:
+
+	r.ServeFiles("/static/", http.Dir("/var/www"))
+
+Routes are tested in the order they were registered, and the first one that
+matches wins. To test a route registered later first, give it a higher
+priority (the default is 0):
+
+	r.HandleFunc("/{page}", PageHandler)
+	r.HandleFunc("/about", AboutHandler).Priority(1)
+
+Route builders panic on malformed templates. To register routes loaded from
+a configuration, use TryHandle, TryPath or TryHost, which return an error
+instead:
+
+	if _, err := r.TryHandle(path, handler); err != nil {
+		log.Printf("skipping route: %v", err)
+	}
+
 Now let's see how to build registered URLs.
 
 Routes can be named. All routes that define a name can have their URLs built,
@@ -161,5 +262,18 @@ we would do:
 	// "/articles/technology/42"
 	path := r.NamedRoutes["article"].URLPath("category", "technology",
 											   "id", "42").String()
+
+When a route doesn't match as expected, GetPathRegexp() and GetHostRegexp()
+return the regular expressions that the route templates were expanded to:
+
+	// "^/articles/([^/]+)/([0-9]+)$"
+	re, err := r.NamedRoutes["article"].GetPathRegexp()
+
+Routes can also carry arbitrary values, for example to generate
+documentation. They don't affect matching:
+
+	route := r.HandleFunc("/articles/{id}", ArticleHandler).
+	  Meta("summary", "Returns an article.")
+	summary := route.GetMeta("summary")
 */
 package mux
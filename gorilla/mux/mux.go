@@ -9,9 +9,13 @@ import (
 	"errors"
 	"fmt"
 	"http"
+	"io"
+	"mime"
 	"path"
 	"regexp"
+	"runtime"
 	"strings"
+	"sync"
 	"url"
 	"gorilla.googlecode.com/hg/gorilla/context"
 )
@@ -30,16 +34,27 @@ const (
 	errMissingPath     string = "Route doesn't have a path."
 	// Empty parameter errors.
 	errEmptyHost       string = "Host() requires a non-zero string, got %q."
+	errEmptyHostExact  string = "HostExact() requires a non-zero string, got %q."
 	errEmptyPath       string = "Path() requires a non-zero string that starts with a slash, got %q."
 	errEmptyPathPrefix string = "PathPrefix() requires a non-zero string that starts with a slash, got %q."
+	errEmptyPathFormat string = "PathWithFormat() requires a non-zero string that starts with a slash, got %q."
 	// Variadic errors.
-	errEmptyHeaders string = "Headers() requires at least a pair of parameters."
-	errEmptyMethods string = "Methods() requires at least one parameter."
-	errEmptyQueries string = "Queries() requires at least a pair of parameters."
-	errEmptySchemes string = "Schemes() requires at least one parameter."
-	errOddHeaders   string = "Headers() requires an even number of parameters, got %v."
-	errOddQueries   string = "Queries() requires an even number of parameters, got %v."
-	errOddURLPairs  string = "URL() requires an even number of parameters, got %v."
+	errEmptyHeaders      string = "Headers() requires at least a pair of parameters."
+	errEmptyHeadersExact string = "HeadersExact() requires at least a pair of parameters."
+	errEmptyFormats      string = "PathWithFormat() requires at least one format."
+	errEmptyMethods      string = "Methods() requires at least one parameter."
+	errEmptyQueries      string = "Queries() requires at least a pair of parameters."
+	errEmptyQueryAbsent  string = "QueryAbsent() requires at least one parameter."
+	errEmptySchemes      string = "Schemes() requires at least one parameter."
+	errOddDefaultHeaders string = "DefaultHeaders() requires an even number of parameters, got %v."
+	errOddHeaders        string = "Headers() requires an even number of parameters, got %v."
+	errOddHeadersExact   string = "HeadersExact() requires an even number of parameters, got %v."
+	errOddQueries        string = "Queries() requires an even number of parameters, got %v."
+	errOddURLPairs       string = "URL() requires an even number of parameters, got %v."
+	// Mounting.
+	errMountNoPathPrefix string = "MountServeMux() requires a route with a path prefix."
+	errNegativeBodySize  string = "MaxBodySize() requires a non-negative size, got %d."
+	errBodyTooLarge      string = "Request body is larger than %d bytes."
 )
 
 // ----------------------------------------------------------------------------
@@ -75,6 +90,24 @@ func CurrentRoute(request *http.Request) *Route {
 	return nil
 }
 
+// methodCtx is the request context that stores the method matched by the
+// Methods matcher of the current route.
+var methodCtx = new(context.Namespace)
+
+// MatchedMethod returns the HTTP method that matched the Methods matcher of
+// the current route, or an empty string if the route doesn't restrict
+// methods.
+//
+// It is the request method at matching time, so a handler shared by several
+// methods can branch on it even if a middleware changed request.Method.
+func MatchedMethod(request *http.Request) string {
+	rv := methodCtx.Get(request)
+	if rv != nil {
+		return rv.(string)
+	}
+	return ""
+}
+
 // ----------------------------------------------------------------------------
 // Router
 // ----------------------------------------------------------------------------
@@ -101,7 +134,9 @@ func CurrentRoute(request *http.Request) *Route {
 // The DefaultRouter is a Router instance ready to register URLs and handlers.
 // If needed, new instances can be created and registered.
 type Router struct {
-	// Routes to be matched, in order.
+	// Routes to be matched, in order. It can be changed directly, by
+	// assigning or appending to it, but not while the router serves
+	// requests. Assigning to an element in place isn't detected.
 	Routes []*Route
 	// Routes by name, for URL building.
 	NamedRoutes map[string]*Route
@@ -109,8 +144,26 @@ type Router struct {
 	rootRouter *Router
 	// Configurable Handler to be used when no route matches.
 	NotFoundHandler http.Handler
+	// Route to be matched when no other route matches. See NotFoundRoute.
+	notFoundRoute *Route
+	// Number of routes registered, used to keep registration order.
+	seq int
 	// See Route.redirectSlash. This defines the default flag for new routes.
 	redirectSlash bool
+	// If true, POST requests can override their method. See UseMethodOverride.
+	methodOverride bool
+	// Routes that can match each method, built on first use. See routesFor.
+	methodRoutes map[string][]*Route
+	// Copy of Routes when methodRoutes was built, and the address of the
+	// first element of Routes when it was last checked, to detect changes.
+	methodRoutesOf   []*Route
+	methodRoutesHead **Route
+	methodMutex      sync.RWMutex
+	// Headers set on every response. See DefaultHeaders.
+	defaultHeaders map[string]string
+	// If true, requests rejected only by Methods matchers are answered as
+	// not found. See HandleMethodNotAllowed.
+	ignoreMethodNotAllowed bool
 }
 
 // root returns the root router, where named routes are stored.
@@ -122,20 +175,157 @@ func (r *Router) root() *Router {
 }
 
 // Match matches registered routes against the request.
+//
+// The not found route, if any, is tested after all other routes.
 func (r *Router) Match(request *http.Request) (match *RouteMatch, ok bool) {
-	for _, route := range r.Routes {
+	for _, route := range r.routesFor(request.Method) {
 		if match, ok = route.Match(request); ok {
 			return
 		}
 	}
+	if r.notFoundRoute != nil {
+		match, ok = r.notFoundRoute.Match(request)
+	}
 	return
 }
 
+// TestMatch matches a request with the given method and URL against the
+// registered routes, without calling any handler. It returns the name of the
+// matched route, which is empty for unnamed routes, and its variables.
+//
+// It is meant for tests of route tables:
+//
+//     if name, vars, ok := r.TestMatch("GET", "/articles/42"); !ok ||
+//         name != "article" || vars["id"] != "42" {
+//         t.Errorf("/articles/42 doesn't match the article route")
+//     }
+//
+// Invalid URLs don't match.
+func (r *Router) TestMatch(method, rawurl string) (name string,
+	vars map[string]string, ok bool) {
+	request, err := http.NewRequest(method, rawurl, nil)
+	if err != nil {
+		return "", nil, false
+	}
+	defer context.DefaultContext.Clear(request)
+	match, ok := r.Match(request)
+	if !ok {
+		return "", nil, false
+	}
+	return match.Route.GetName(), Vars(request), true
+}
+
+// routesFor returns the routes that can match a request with the given
+// method, in order: routes whose method matchers reject it are left out, so
+// that their other matchers aren't tested.
+//
+// The routes are partitioned by method on first use, and again when Routes
+// changed since, or after matchers are added, see resetMethodRoutes.
+func (r *Router) routesFor(method string) []*Route {
+	r.methodMutex.RLock()
+	if r.methodRoutesCurrent() {
+		defer r.methodMutex.RUnlock()
+		return r.lookupMethodRoutes(method)
+	}
+	r.methodMutex.RUnlock()
+	r.methodMutex.Lock()
+	defer r.methodMutex.Unlock()
+	if !r.methodRoutesCurrent() {
+		if r.methodRoutes == nil || !sameRoutes(r.methodRoutesOf, r.Routes) {
+			r.partitionRoutes()
+		}
+		r.methodRoutesHead = r.routesHead()
+	}
+	return r.lookupMethodRoutes(method)
+}
+
+// methodRoutesCurrent returns true if methodRoutes was built from the current
+// Routes. To keep matching cheap, only the length of Routes and the address
+// of its first element are compared, which detects assigning a new slice to
+// Routes, appending to it or reslicing it.
+func (r *Router) methodRoutesCurrent() bool {
+	return r.methodRoutes != nil && len(r.methodRoutesOf) == len(r.Routes) &&
+		r.methodRoutesHead == r.routesHead()
+}
+
+// routesHead returns the address of the first element of Routes, or nil if
+// it is empty.
+func (r *Router) routesHead() **Route {
+	if len(r.Routes) == 0 {
+		return nil
+	}
+	return &r.Routes[0]
+}
+
+// sameRoutes returns true if two lists hold the same routes in the same
+// order.
+func sameRoutes(a, b []*Route) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// partitionRoutes builds methodRoutes from Routes.
+func (r *Router) partitionRoutes() {
+	r.methodRoutes = make(map[string][]*Route)
+	r.methodRoutesOf = make([]*Route, len(r.Routes))
+	copy(r.methodRoutesOf, r.Routes)
+	// Collect the methods routes are restricted to. Methods not named by any
+	// route share the "" entry, with the routes that accept any method.
+	methods := []string{""}
+	for _, route := range r.Routes {
+		if route.cors != nil {
+			methods = append(methods, "OPTIONS")
+		}
+		for _, m := range route.matchers {
+			if mm, ok := (*m).(*methodMatcher); ok {
+				methods = append(methods, mm.methods...)
+			}
+		}
+	}
+	for _, m := range methods {
+		if _, ok := r.methodRoutes[m]; ok {
+			continue
+		}
+		routes := make([]*Route, 0)
+		for _, route := range r.Routes {
+			if route.acceptsMethod(m) {
+				routes = append(routes, route)
+			}
+		}
+		r.methodRoutes[m] = routes
+	}
+}
+
+// lookupMethodRoutes returns the partition of methodRoutes for a method.
+func (r *Router) lookupMethodRoutes(method string) []*Route {
+	if routes, ok := r.methodRoutes[method]; ok {
+		return routes
+	}
+	return r.methodRoutes[""]
+}
+
+// resetMethodRoutes discards the routes partitioned by routesFor.
+func (r *Router) resetMethodRoutes() {
+	r.methodMutex.Lock()
+	r.methodRoutes = nil
+	r.methodMutex.Unlock()
+}
+
 // ServeHTTP dispatches the handler registered in the matched route.
 //
 // When there is a match, the route variables can be retrieved calling
 // mux.Vars(request).
 func (r *Router) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	for k, v := range r.defaultHeaders {
+		writer.Header().Set(k, v)
+	}
 	// Clean path to canonical form and redirect.
 	// (this comes from the http package)
 	if p := cleanPath(request.URL.Path); p != request.URL.Path {
@@ -143,10 +333,21 @@ func (r *Router) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 		writer.WriteHeader(http.StatusMovedPermanently)
 		return
 	}
+	if r.methodOverride && request.Method == "POST" {
+		if m := overrideMethod(request); m != "" {
+			request.Method = m
+		}
+	}
 	var handler http.Handler
-	if match, ok := r.Match(request); ok {
+	match, ok := r.Match(request)
+	if ok {
 		handler = match.Handler
 	}
+	if (!ok || match.Route == r.notFoundRoute) && !r.ignoreMethodNotAllowed {
+		if allowed := r.allowedMethods(request); len(allowed) > 0 {
+			handler = &methodNotAllowedHandler{allowed}
+		}
+	}
 	if handler == nil {
 		if r.NotFoundHandler == nil {
 			r.NotFoundHandler = http.NotFoundHandler()
@@ -158,15 +359,46 @@ func (r *Router) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 }
 
 // AddRoute registers a route in the router.
+//
+// Routes are kept sorted by descending priority and then by registration
+// order. See Route.Priority.
 func (r *Router) AddRoute(route *Route) *Router {
 	if r.Routes == nil {
 		r.Routes = make([]*Route, 0)
 	}
 	route.router = r
-	r.Routes = append(r.Routes, route)
+	r.seq++
+	route.seq = r.seq
+	r.insertRoute(route)
 	return r
 }
 
+// insertRoute inserts a route in Routes, after all routes that must be
+// tested before it.
+func (r *Router) insertRoute(route *Route) {
+	i := 0
+	for i < len(r.Routes) && !route.before(r.Routes[i]) {
+		i++
+	}
+	r.Routes = append(r.Routes, nil)
+	copy(r.Routes[i+1:], r.Routes[i:])
+	r.Routes[i] = route
+	r.resetMethodRoutes()
+}
+
+// removeRoute removes a route from Routes. It returns false if the route
+// wasn't registered.
+func (r *Router) removeRoute(route *Route) bool {
+	for i, v := range r.Routes {
+		if v == route {
+			r.Routes = append(r.Routes[:i], r.Routes[i+1:]...)
+			r.resetMethodRoutes()
+			return true
+		}
+	}
+	return false
+}
+
 // RedirectSlash defines the default RedirectSlash behavior for new routes.
 //
 // See Route.RedirectSlash.
@@ -175,6 +407,129 @@ func (r *Router) RedirectSlash(value bool) *Router {
 	return r
 }
 
+// HandleMethodNotAllowed defines whether the router answers requests that
+// only failed to match because of the Methods matchers of its routes with a
+// "405 Method Not Allowed" response, listing the methods that would match in
+// the Allow header. This is the default.
+//
+// Routes of subrouters are taken into account, and the 405 response takes
+// precedence over NotFoundHandler and NotFoundRoute. When false, such
+// requests are handled like any other request that doesn't match, so that
+// clients can't tell which methods exist.
+func (r *Router) HandleMethodNotAllowed(value bool) *Router {
+	r.ignoreMethodNotAllowed = !value
+	return r
+}
+
+// allowedMethods returns the methods that the Methods matchers of the
+// router's routes, including the routes of subrouters, would accept for a
+// request that matches none of them because of its method.
+func (r *Router) allowedMethods(request *http.Request) []string {
+	methods, _ := r.methodsFor(request)
+	allowed := make([]string, 0, len(methods))
+	for _, method := range methods {
+		if method != request.Method {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
+
+// methodsFor returns the methods accepted by the routes that match the
+// request when their method matchers are ignored, or nil if one of them
+// accepts any method. It returns false if no route matches.
+func (r *Router) methodsFor(request *http.Request) ([]string, bool) {
+	methods := make([]string, 0)
+	matched := false
+	for _, route := range r.Routes {
+		routeMethods, ok := route.methodsFor(request)
+		if !ok {
+			continue
+		}
+		if routeMethods == nil {
+			return nil, true
+		}
+		matched = true
+		for _, method := range routeMethods {
+			if !matchInArray(methods, method) {
+				methods = append(methods, method)
+			}
+		}
+	}
+	return methods, matched
+}
+
+// UseMethodOverride makes the router replace the method of POST requests by
+// the one given in the X-HTTP-Method-Override header or, if it is not set,
+// in the "_method" form field. This is for clients that can only send GET
+// and POST requests. The method is replaced before any route is tested, so
+// Methods matchers see the overridden method.
+//
+// The form field is only read from "application/x-www-form-urlencoded"
+// bodies, which are then parsed; the form values remain available to
+// handlers through request.Form. Other request bodies are not read.
+func (r *Router) UseMethodOverride() *Router {
+	r.methodOverride = true
+	return r
+}
+
+// DefaultHeaders sets headers on every response served by the router, for
+// example:
+//
+//     r := new(mux.Router)
+//     r.DefaultHeaders("X-Content-Type-Options", "nosniff")
+//
+// It accepts a sequence of key/value pairs. The headers are set before the
+// handler is called, so handlers can still change or delete them before
+// writing the response. Calling it again adds to the previous headers.
+//
+// Only the router that serves the request sets them: default headers of a
+// subrouter are ignored.
+func (r *Router) DefaultHeaders(pairs ...string) *Router {
+	headers := stringMapFromPairs(errOddDefaultHeaders, pairs...)
+	if r.defaultHeaders == nil {
+		r.defaultHeaders = make(map[string]string, len(headers))
+	}
+	for k, v := range headers {
+		r.defaultHeaders[k] = v
+	}
+	return r
+}
+
+// overrideMethod returns the method requested by a method override, or an
+// empty string.
+func overrideMethod(request *http.Request) string {
+	m := request.Header.Get("X-HTTP-Method-Override")
+	if m == "" {
+		// Other bodies, like multipart uploads or JSON, are left unread.
+		ct, _ := mime.ParseMediaType(request.Header.Get("Content-Type"))
+		if ct == "application/x-www-form-urlencoded" {
+			m = request.FormValue("_method")
+		}
+	}
+	return strings.ToUpper(strings.TrimSpace(m))
+}
+
+// NotFoundRoute returns a route that is only tested when no other route in
+// the router matches, regardless of the order in which routes are registered.
+//
+// Unlike NotFoundHandler, it is a normal route: it can have matchers and it
+// sets the current route and variables in the request context. For example:
+//
+//     r := new(mux.Router)
+//     r.NotFoundRoute().HandlerFunc(NotFoundHandler)
+//
+// The same route is returned on subsequent calls.
+func (r *Router) NotFoundRoute() *Route {
+	if r.notFoundRoute == nil {
+		route := newRoute()
+		route.router = r
+		route.redirectSlash = r.redirectSlash
+		r.notFoundRoute = route
+	}
+	return r.notFoundRoute
+}
+
 // Convenience route factories ------------------------------------------------
 
 // NewRoute creates an empty route and registers it in the router.
@@ -200,6 +555,33 @@ func (r *Router) HandleFunc(path string, handler func(http.ResponseWriter,
 	return r.NewRoute().HandleFunc(path, handler)
 }
 
+// TryHandle is like Handle, but it returns an error instead of panicking if
+// the path template is malformed. In that case no route is registered.
+//
+// This is useful to register routes loaded from a configuration, to report
+// the bad ones instead of crashing.
+func (r *Router) TryHandle(path string, handler http.Handler) (*Route, error) {
+	route := r.NewRoute()
+	if _, err := route.TryPath(path); err != nil {
+		r.removeRoute(route)
+		return nil, err
+	}
+	return route.Handler(handler), nil
+}
+
+// ServeFiles registers a new route that serves static files from root for
+// all paths starting with the given prefix. The prefix is stripped before
+// looking up the file, and paths containing ".." are rejected. For example:
+//
+//     r := new(mux.Router)
+//     // "/static/css/site.css" is served from "/var/www/css/site.css".
+//     r.ServeFiles("/static/", http.Dir("/var/www"))
+func (r *Router) ServeFiles(path string, root http.FileSystem) *Route {
+	route := r.NewRoute().PathPrefix(path)
+	return route.Handler(&prefixStripper{route.pathTemplate.Regexp,
+		&fileHandler{http.FileServer(root)}})
+}
+
 // ----------------------------------------------------------------------------
 // Route
 // ----------------------------------------------------------------------------
@@ -222,6 +604,17 @@ type Route struct {
 	redirectSlash bool
 	// The name associated with this route.
 	name string
+	// Routes with higher priority are tested first. See Priority.
+	priority int
+	// Registration order in the router, to break priority ties.
+	seq int
+	// Cross-origin resource sharing options. See CORS.
+	cors *CORSOptions
+	// Maximum request body size, if limitBody is true. See MaxBodySize.
+	maxBodySize int64
+	limitBody   bool
+	// Arbitrary values attached to the route. See Meta.
+	meta map[string]interface{}
 }
 
 // newRoute returns a new Route instance.
@@ -239,6 +632,13 @@ func (r *Route) Clone() *Route {
 	// The name field is not cloned.
 	matchers := make([]*routeMatcher, len(r.matchers))
 	copy(matchers, r.matchers)
+	var meta map[string]interface{}
+	if r.meta != nil {
+		meta = make(map[string]interface{}, len(r.meta))
+		for k, v := range r.meta {
+			meta[k] = v
+		}
+	}
 	return &Route{
 		router:        r.router,
 		handler:       r.handler,
@@ -246,6 +646,11 @@ func (r *Route) Clone() *Route {
 		hostTemplate:  r.hostTemplate,
 		pathTemplate:  r.pathTemplate,
 		redirectSlash: r.redirectSlash,
+		priority:      r.priority,
+		cors:          r.cors,
+		maxBodySize:   r.maxBodySize,
+		limitBody:     r.limitBody,
+		meta:          meta,
 	}
 }
 
@@ -255,7 +660,7 @@ func (r *Route) Clone() *Route {
 func (r *Route) Match(req *http.Request) (*RouteMatch, bool) {
 	var hostMatches, pathMatches []string
 	if r.hostTemplate != nil {
-		hostMatches = r.hostTemplate.Regexp.FindStringSubmatch(req.URL.Host)
+		hostMatches = r.hostTemplate.Regexp.FindStringSubmatch(requestHost(req))
 		if hostMatches == nil {
 			return nil, false
 		}
@@ -290,10 +695,30 @@ func (r *Route) Match(req *http.Request) (*RouteMatch, bool) {
 		}
 	}
 	var match *RouteMatch
+	// CORS preflight requests use the OPTIONS method, so they bypass the
+	// method matchers.
+	preflight := r.cors != nil && isPreflight(req)
+	methodMatched := false
+	var matcherVars RouteVars
 	if r.matchers != nil {
 		for _, matcher := range r.matchers {
+			_, isMethod := (*matcher).(*methodMatcher)
+			if isMethod && preflight {
+				continue
+			}
+			if m, ok := (*matcher).(*varMatcher); ok {
+				if matcherVars == nil {
+					matcherVars = make(RouteVars)
+				}
+				if !m.matcherFunc(req, matcherVars) {
+					return nil, false
+				}
+				continue
+			}
 			if rv, ok := (*matcher).Match(req); !ok {
 				return nil, false
+			} else if isMethod {
+				methodMatched = true
 			} else if rv != nil {
 				match = rv
 				break
@@ -311,15 +736,34 @@ func (r *Route) Match(req *http.Request) (*RouteMatch, bool) {
 		for k, v := range r.pathTemplate.VarsN {
 			vars[v] = pathMatches[k+1]
 		}
+		if r.pathTemplate.Formats != nil {
+			vars["format"] = pathMatches[len(r.pathTemplate.VarsN)+1]
+		}
+	}
+	for k, v := range matcherVars {
+		if _, ok := vars[k]; !ok {
+			vars[k] = v
+		}
 	}
 	if match == nil {
 		match = &RouteMatch{Route: r, Handler: r.handler}
 	}
+	if preflight {
+		match.Handler = &corsHandler{route: r}
+	} else if r.cors != nil && match.Handler != nil {
+		match.Handler = &corsHandler{route: r, handler: match.Handler}
+	}
+	if r.limitBody && match.Handler != nil {
+		match.Handler = &maxBodyHandler{r.maxBodySize, match.Handler}
+	}
 	if redirectURL != "" {
 		match.Handler = http.RedirectHandler(redirectURL, 301)
 	}
 	ctx.Set(req, vars)
 	routeCtx.Set(req, match.Route)
+	if methodMatched {
+		methodCtx.Set(req, req.Method)
+	}
 	return match, true
 }
 
@@ -486,6 +930,13 @@ func reverseRoute(tpl *parsedTemplate, values map[string]string) (rv string, err
 		urlValues[k] = value
 	}
 	rv = fmt.Sprintf(tpl.Reverse, urlValues...)
+	if format := values["format"]; tpl.Formats != nil && format != "" {
+		if !matchInArray(tpl.Formats, format) {
+			err = muxError(errBadRouteVar, format, strings.Join(tpl.Formats, "|"))
+			return
+		}
+		rv += "." + format
+	}
 	if !tpl.Regexp.MatchString(rv) {
 		// The URL is checked against the full regexp, instead of checking
 		// individual variables. This is faster but to provide a good error
@@ -526,6 +977,215 @@ func (r *Route) HandleFunc(path string, handler func(http.ResponseWriter,
 	return r.Path(path).Handler(http.HandlerFunc(handler))
 }
 
+// MountServeMux sets an http.ServeMux as the handler for the route, which
+// must have a path prefix. The matched prefix is stripped from the request
+// path before the ServeMux handles it, so existing ServeMux patterns work
+// unchanged under the prefix. For example:
+//
+//     legacy := http.NewServeMux()
+//     legacy.HandleFunc("/users/", UsersHandler)
+//
+//     r := new(mux.Router)
+//     // "/legacy/users/1" is handled by UsersHandler as "/users/1".
+//     r.NewRoute().PathPrefix("/legacy/").MountServeMux(legacy)
+//
+// The ServeMux receives a copy of the request, so route variables aren't
+// available to its handlers.
+func (r *Route) MountServeMux(mux *http.ServeMux) *Route {
+	if r.pathTemplate == nil || !r.pathTemplate.Prefix {
+		panic(errMountNoPathPrefix)
+	}
+	return r.Handler(&prefixStripper{r.pathTemplate.Regexp, mux})
+}
+
+// prefixStripper is an http.Handler that removes the part of the request
+// path matched by a regexp before calling another handler.
+type prefixStripper struct {
+	prefix  *regexp.Regexp
+	handler http.Handler
+}
+
+func (h *prefixStripper) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	prefix := h.prefix.FindString(req.URL.Path)
+	// Keep the slash that ends the prefix, if any.
+	if strings.HasSuffix(prefix, "/") {
+		prefix = prefix[:len(prefix)-1]
+	}
+	u := *req.URL
+	u.Path = req.URL.Path[len(prefix):]
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	r2 := *req
+	r2.URL = &u
+	h.handler.ServeHTTP(w, &r2)
+}
+
+// fileHandler is an http.Handler that rejects request paths containing ".."
+// elements before calling a file server.
+type fileHandler struct {
+	handler http.Handler
+}
+
+func (h *fileHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	for _, part := range strings.Split(req.URL.Path, "/") {
+		if part == ".." {
+			http.NotFound(w, req)
+			return
+		}
+	}
+	h.handler.ServeHTTP(w, req)
+}
+
+// CORSOptions configures cross-origin resource sharing for a route.
+//
+// See Route.CORS.
+type CORSOptions struct {
+	// Origin allowed to access the route. If empty, "*" is used.
+	AllowOrigin string
+	// Methods allowed in cross-origin requests, sent in responses to
+	// preflight requests. If empty, the methods defined in the route's
+	// Methods matchers are used.
+	AllowMethods []string
+	// Request headers allowed in cross-origin requests, sent in responses to
+	// preflight requests.
+	AllowHeaders []string
+}
+
+// CORS enables cross-origin resource sharing for the route.
+//
+// Preflight requests, OPTIONS requests with an
+// Access-Control-Request-Method header, are answered by the route itself:
+// they bypass the Methods matchers and don't call the route handler. For
+// other requests that match, the Access-Control-Allow-Origin header is added
+// to the handler response. For example:
+//
+//     r := new(mux.Router)
+//     r.HandleFunc("/api/products", ProductsHandler).
+//       Methods("GET", "POST").
+//       CORS(mux.CORSOptions{AllowHeaders: []string{"Content-Type"}})
+func (r *Route) CORS(options CORSOptions) *Route {
+	if options.AllowOrigin == "" {
+		options.AllowOrigin = "*"
+	}
+	r.cors = &options
+	if r.router != nil {
+		r.router.resetMethodRoutes()
+	}
+	return r
+}
+
+// isPreflight returns true if the request is a CORS preflight request.
+func isPreflight(req *http.Request) bool {
+	return req.Method == "OPTIONS" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// methodNotAllowedHandler answers a request with a "405 Method Not Allowed"
+// response listing the allowed methods.
+type methodNotAllowedHandler struct {
+	allowed []string
+}
+
+func (h *methodNotAllowedHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Allow", strings.Join(h.allowed, ", "))
+	http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+}
+
+// corsHandler is an http.Handler that sets the CORS headers of a route and
+// then calls the route handler. If there's no handler it answers a
+// preflight request.
+type corsHandler struct {
+	route   *Route
+	handler http.Handler
+}
+
+func (h *corsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	options := h.route.cors
+	w.Header().Set("Access-Control-Allow-Origin", options.AllowOrigin)
+	if h.handler != nil {
+		h.handler.ServeHTTP(w, req)
+		return
+	}
+	methods := options.AllowMethods
+	if len(methods) == 0 {
+		for _, m := range h.route.matchers {
+			if mm, ok := (*m).(*methodMatcher); ok {
+				methods = append(methods, mm.methods...)
+			}
+		}
+	}
+	if len(methods) > 0 {
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	}
+	if len(options.AllowHeaders) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers",
+			strings.Join(options.AllowHeaders, ", "))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// MaxBodySize limits the size of request bodies accepted by the route
+// handler to n bytes.
+//
+// Requests that declare a larger Content-Length still match the route, so
+// that no other route handles them, but they are answered with a
+// "413 Request Entity Too Large" response without calling the handler.
+// If the length is unknown, as for chunked requests, the handler is called
+// and reading more than n bytes from the request body returns an error.
+func (r *Route) MaxBodySize(n int64) *Route {
+	if n < 0 {
+		panic(fmt.Sprintf(errNegativeBodySize, n))
+	}
+	r.maxBodySize = n
+	r.limitBody = true
+	return r
+}
+
+// maxBodyHandler is an http.Handler that rejects request bodies larger than
+// a limit before calling another handler.
+type maxBodyHandler struct {
+	limit   int64
+	handler http.Handler
+}
+
+func (h *maxBodyHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.ContentLength > h.limit {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge),
+			http.StatusRequestEntityTooLarge)
+		return
+	}
+	if req.ContentLength < 0 && req.Body != nil {
+		req.Body = &limitedBody{ReadCloser: req.Body, limit: h.limit}
+	}
+	h.handler.ServeHTTP(w, req)
+}
+
+// limitedBody is a request body that returns an error once more than limit
+// bytes are read.
+type limitedBody struct {
+	io.ReadCloser
+	limit int64
+	read  int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.read > b.limit {
+		return 0, muxError(errBodyTooLarge, b.limit)
+	}
+	// Read up to one byte past the limit to tell a body of exactly limit
+	// bytes from a larger one.
+	if max := b.limit - b.read + 1; int64(len(p)) > max {
+		p = p[:max]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		return n - 1, muxError(errBodyTooLarge, b.limit)
+	}
+	return n, err
+}
+
 // Name sets the route name, used to build URLs.
 //
 // A name must be unique for a router. If the name was registered already
@@ -545,6 +1205,70 @@ func (r *Route) GetName() string {
 	return r.name
 }
 
+// GetMethods returns the methods the route's Methods matchers accept, or nil
+// if the route accepts any method. If Methods was called several times, only
+// the methods accepted by all of them are returned.
+func (r *Route) GetMethods() []string {
+	var sets [][]string
+	for _, m := range r.matchers {
+		if mm, ok := (*m).(*methodMatcher); ok {
+			sets = append(sets, mm.methods)
+		}
+	}
+	return intersect(sets)
+}
+
+// GetSchemes returns the schemes the route's Schemes matchers accept, or nil
+// if the route accepts any scheme. If Schemes was called several times, only
+// the schemes accepted by all of them are returned.
+func (r *Route) GetSchemes() []string {
+	var sets [][]string
+	for _, m := range r.matchers {
+		if sm, ok := (*m).(*schemeMatcher); ok {
+			sets = append(sets, sm.schemes)
+		}
+	}
+	return intersect(sets)
+}
+
+// Meta attaches a value to the route under the given key, for example to
+// describe it in generated documentation. It doesn't affect matching.
+func (r *Route) Meta(key string, value interface{}) *Route {
+	if r.meta == nil {
+		r.meta = make(map[string]interface{})
+	}
+	r.meta[key] = value
+	return r
+}
+
+// GetMeta returns the value attached to the route under the given key, or
+// nil if there is none.
+func (r *Route) GetMeta(key string) interface{} {
+	return r.meta[key]
+}
+
+// GetHostRegexp returns the expanded regular expression used to match the
+// route host. It is useful to debug a route that doesn't match.
+//
+// The route must have a host defined.
+func (r *Route) GetHostRegexp() (string, error) {
+	if r.hostTemplate == nil {
+		return "", muxError(errMissingHost)
+	}
+	return r.hostTemplate.Regexp.String(), nil
+}
+
+// GetPathRegexp returns the expanded regular expression used to match the
+// route path. It is useful to debug a route that doesn't match.
+//
+// The route must have a path defined.
+func (r *Route) GetPathRegexp() (string, error) {
+	if r.pathTemplate == nil {
+		return "", muxError(errMissingPath)
+	}
+	return r.pathTemplate.Regexp.String(), nil
+}
+
 // RedirectSlash defines the redirectSlash behavior for this route.
 //
 // When true, if the route path is /path/, accessing /path will redirect to
@@ -554,14 +1278,97 @@ func (r *Route) RedirectSlash(value bool) *Route {
 	return r
 }
 
+// Priority defines the order in which this route is tested by the router.
+//
+// Routes are tested in descending priority, and routes with the same priority
+// are tested in the order they were registered. The default priority is 0,
+// so a route registered later can still be tested first:
+//
+//     r.HandleFunc("/{page}", PageHandler)
+//     r.HandleFunc("/about", AboutHandler).Priority(1)
+//
+// Priorities only order routes of the same router; the not found route is
+// always tested last.
+func (r *Route) Priority(priority int) *Route {
+	r.priority = priority
+	// A route that isn't registered, such as a clone, is only moved once it
+	// is added to the router.
+	if r.router != nil && r.router.removeRoute(r) {
+		r.router.insertRoute(r)
+	}
+	return r
+}
+
+// before returns true if this route must be tested before the other.
+func (r *Route) before(other *Route) bool {
+	if r.priority != other.priority {
+		return r.priority > other.priority
+	}
+	return r.seq < other.seq
+}
+
 // Route matchers -------------------------------------------------------------
 
 // addMatcher adds a matcher to the array of route matchers.
 func (r *Route) addMatcher(m routeMatcher) *Route {
 	r.matchers = append(r.matchers, &m)
+	if r.router != nil {
+		r.router.resetMethodRoutes()
+	}
 	return r
 }
 
+// methodsFor tests the route against the request, ignoring the method
+// matchers of the route and of its subrouters. If the other matchers match,
+// it returns true and the methods the route accepts, or nil if it accepts
+// any method.
+func (r *Route) methodsFor(request *http.Request) ([]string, bool) {
+	if r.hostTemplate != nil &&
+		!r.hostTemplate.Regexp.MatchString(requestHost(request)) {
+		return nil, false
+	}
+	if r.pathTemplate != nil &&
+		!r.pathTemplate.Regexp.MatchString(request.URL.Path) {
+		return nil, false
+	}
+	var sets [][]string
+	for _, matcher := range r.matchers {
+		switch m := (*matcher).(type) {
+		case *methodMatcher:
+			sets = append(sets, m.methods)
+		case *Router:
+			methods, ok := m.methodsFor(request)
+			if !ok {
+				return nil, false
+			}
+			if methods != nil {
+				sets = append(sets, methods)
+			}
+		default:
+			if _, ok := m.Match(request); !ok {
+				return nil, false
+			}
+		}
+	}
+	return intersect(sets), true
+}
+
+// acceptsMethod returns false if the route's method matchers reject the
+// given method. An empty method stands for any method not named by them.
+//
+// CORS routes accept OPTIONS, used by preflight requests.
+func (r *Route) acceptsMethod(method string) bool {
+	if r.cors != nil && method == "OPTIONS" {
+		return true
+	}
+	for _, m := range r.matchers {
+		if mm, ok := (*m).(*methodMatcher); ok && !matchInArray(mm.methods, method) {
+			return false
+		}
+	}
+	return true
+}
+
 // Headers adds a matcher to match the request against header values.
 //
 // It accepts a sequence of key/value pairs to be matched. For example:
@@ -581,6 +1388,29 @@ func (r *Route) Headers(pairs ...string) *Route {
 	return r.addMatcher(&headerMatcher{headers: headers})
 }
 
+// HeadersExact adds a matcher to match the request against header values,
+// requiring each header to be sent with a single value.
+//
+// It accepts a sequence of key/value pairs, like Headers(). While Headers()
+// matches if the value is one of the values sent for a header, HeadersExact()
+// only matches if it is the sole value. For example:
+//
+//     r := new(mux.Router)
+//     r.NewRoute().HeadersExact("Accept", "application/json")
+//
+// The above route will not match if the request has another Accept header
+// besides "application/json".
+//
+// It the value is an empty string, it will match any value if the key is set
+// with a single value.
+func (r *Route) HeadersExact(pairs ...string) *Route {
+	headers := stringMapFromPairs(errOddHeadersExact, pairs...)
+	if len(headers) == 0 {
+		panic(errEmptyHeadersExact)
+	}
+	return r.addMatcher(&headerExactMatcher{headers: headers})
+}
+
 // Host adds a matcher to match the request against the URL host.
 //
 // It accepts a template with zero or more URL variables enclosed by {}.
@@ -599,6 +1429,9 @@ func (r *Route) Headers(pairs ...string) *Route {
 //
 // Variable names must be unique in a given route. They can be retrieved
 // calling mux.Vars(request).
+//
+// If the request URL has no host, as is usual for server requests, the
+// request's Host field is matched instead.
 func (r *Route) Host(template string) *Route {
 	if template == "" {
 		panic(fmt.Sprintf(errEmptyHost, template))
@@ -614,11 +1447,66 @@ func (r *Route) Host(template string) *Route {
 	return r
 }
 
+// TryHost is like Host, but it returns an error instead of panicking if the
+// template is malformed. In that case the route is left unchanged.
+func (r *Route) TryHost(template string) (*Route, error) {
+	return r.try(func() { r.Host(template) })
+}
+
+// try calls fn, which configures the route, and returns the panic it raised
+// because of a bad argument, if any, as an error. Runtime errors, such as a
+// nil pointer dereference, are bugs and keep panicking.
+func (r *Route) try(fn func()) (route *Route, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			switch v := rec.(type) {
+			case runtime.Error:
+				panic(rec)
+			case error:
+				err = v
+			case string:
+				err = errors.New(v)
+			default:
+				panic(rec)
+			}
+			route = nil
+		}
+	}()
+	fn()
+	return r, nil
+}
+
+// HostExact adds a matcher to match the request against a fixed host.
+//
+// Unlike Host, it doesn't use a template or a regexp: the request host must
+// be equal to the given one, ignoring case and the port, if any. For example,
+// this route matches "www.domain.com", "WWW.Domain.com" and
+// "www.domain.com:8080":
+//
+//     r := new(mux.Router)
+//     r.NewRoute().HostExact("www.domain.com")
+func (r *Route) HostExact(host string) *Route {
+	if host == "" {
+		panic(fmt.Sprintf(errEmptyHostExact, host))
+	}
+	return r.addMatcher(&hostExactMatcher{host: strings.ToLower(host)})
+}
+
 // Matcher adds a matcher to match the request using a custom function.
 func (r *Route) Matcher(matcherFunc MatcherFunc) *Route {
 	return r.addMatcher(&customMatcher{matcherFunc: matcherFunc})
 }
 
+// MatcherVars adds a matcher to match the request using a custom function
+// that can also set route variables.
+//
+// The variables set by the function are available through Vars() if the
+// route matches. They don't override the variables from the host or path
+// templates.
+func (r *Route) MatcherVars(matcherFunc VarMatcherFunc) *Route {
+	return r.addMatcher(&varMatcher{matcherFunc: matcherFunc})
+}
+
 // Methods adds a matcher to match the request against HTTP methods.
 //
 // It accepts a sequence of one or more methods to be matched, e.g.:
@@ -666,6 +1554,44 @@ func (r *Route) Path(template string) *Route {
 	return r
 }
 
+// PathWithFormat adds a matcher to match the request against a URL path
+// template, like Path, optionally followed by an extension naming one of the
+// given formats. For example:
+//
+//     r := new(mux.Router)
+//     r.NewRoute().PathWithFormat("/users/{id}", "json", "xml")
+//
+// The above route matches "/users/42", "/users/42.json" and "/users/42.xml".
+// The format is stored in the "format" route variable, which is empty if the
+// path has no extension. To build the URL of a route with a format, pass a
+// "format" variable.
+//
+// Variables with the default pattern stop before the extension. Variables
+// with a custom pattern that matches dots, like {id:.+}, may consume it.
+func (r *Route) PathWithFormat(template string, formats ...string) *Route {
+	if template == "" || template[0] != '/' {
+		panic(fmt.Sprintf(errEmptyPathFormat, template))
+	}
+	if len(formats) == 0 {
+		panic(errEmptyFormats)
+	}
+	tpl := &parsedTemplate{Template: template, Formats: formats}
+	names := variableNames(r.hostTemplate)
+	*names = append(*names, "format")
+	err := parseTemplate(tpl, "[^/]+?", false, r.redirectSlash, names)
+	if err != nil {
+		panic(err)
+	}
+	r.pathTemplate = tpl
+	return r
+}
+
+// TryPath is like Path, but it returns an error instead of panicking if the
+// template is malformed. In that case the route is left unchanged.
+func (r *Route) TryPath(template string) (*Route, error) {
+	return r.try(func() { r.Path(template) })
+}
+
 // PathPrefix adds a matcher to match the request against a URL path prefix.
 func (r *Route) PathPrefix(template string) *Route {
 	if template == "" || template[0] != '/' {
@@ -701,6 +1627,35 @@ func (r *Route) Queries(pairs ...string) *Route {
 	return r.addMatcher(&queryMatcher{queries: queries})
 }
 
+// QueryAbsent adds a matcher that only matches if none of the given keys are
+// set in the URL query. For example:
+//
+//     r := new(mux.Router)
+//     r.NewRoute().Path("/products").Queries("format", "")
+//     r.NewRoute().Path("/products").QueryAbsent("format")
+//
+// The second route matches "/products" but not "/products?format=" or
+// "/products?format=json": a key set to an empty value is not absent.
+func (r *Route) QueryAbsent(keys ...string) *Route {
+	if len(keys) == 0 {
+		panic(errEmptyQueryAbsent)
+	}
+	return r.addMatcher(&queryAbsentMatcher{keys: keys})
+}
+
+// RequireClientCert adds a matcher that only matches requests made over TLS
+// with a client certificate, as in mutual TLS authentication.
+func (r *Route) RequireClientCert() *Route {
+	return r.addMatcher(&clientCertMatcher{})
+}
+
+// WebSocket adds a matcher that only matches WebSocket handshake requests:
+// requests with an "Upgrade" token in the Connection header and an Upgrade
+// header set to "websocket", ignoring case.
+func (r *Route) WebSocket() *Route {
+	return r.addMatcher(&webSocketMatcher{})
+}
+
 // Schemes adds a matcher to match the request against URL schemes.
 //
 // It accepts a sequence of one or more schemes to be matched, e.g.:
@@ -728,6 +1683,10 @@ type RouteMatch struct {
 // MatcherFunc is the type used by custom matchers.
 type MatcherFunc func(*http.Request) bool
 
+// VarMatcherFunc is the type used by custom matchers that set route
+// variables. Variables are set by adding them to the given map.
+type VarMatcherFunc func(*http.Request, map[string]string) bool
+
 // routeMatcher is the interface used by the router, route and route matchers.
 //
 // Only Router and Route actually return a route; it indicates a final match.
@@ -745,6 +1704,26 @@ func (m *customMatcher) Match(request *http.Request) (*RouteMatch, bool) {
 	return nil, m.matcherFunc(request)
 }
 
+// varMatcher matches the request using a custom matcher function that can
+// set route variables. Route.Match calls the function directly to collect
+// them.
+type varMatcher struct {
+	matcherFunc VarMatcherFunc
+}
+
+func (m *varMatcher) Match(request *http.Request) (*RouteMatch, bool) {
+	return nil, m.matcherFunc(request, make(map[string]string))
+}
+
+// hostExactMatcher matches the request against a fixed lowercase host.
+type hostExactMatcher struct {
+	host string
+}
+
+func (m *hostExactMatcher) Match(request *http.Request) (*RouteMatch, bool) {
+	return nil, strings.ToLower(stripPort(requestHost(request))) == m.host
+}
+
 // headerMatcher matches the request against header values.
 type headerMatcher struct {
 	headers map[string]string
@@ -754,6 +1733,22 @@ func (m *headerMatcher) Match(request *http.Request) (*RouteMatch, bool) {
 	return nil, matchMap(m.headers, request.Header, true)
 }
 
+// headerExactMatcher matches the request against header values, requiring
+// a single value for each header.
+type headerExactMatcher struct {
+	headers map[string]string
+}
+
+func (m *headerExactMatcher) Match(request *http.Request) (*RouteMatch, bool) {
+	for k, v := range m.headers {
+		values := request.Header[http.CanonicalHeaderKey(k)]
+		if len(values) != 1 || (v != "" && values[0] != v) {
+			return nil, false
+		}
+	}
+	return nil, true
+}
+
 // methodMatcher matches the request against HTTP methods.
 type methodMatcher struct {
 	methods []string
@@ -772,6 +1767,45 @@ func (m *queryMatcher) Match(request *http.Request) (*RouteMatch, bool) {
 	return nil, matchMap(m.queries, request.URL.Query(), false)
 }
 
+// queryAbsentMatcher matches the request if URL query keys are not set.
+type queryAbsentMatcher struct {
+	keys []string
+}
+
+func (m *queryAbsentMatcher) Match(request *http.Request) (*RouteMatch, bool) {
+	values := request.URL.Query()
+	for _, k := range m.keys {
+		if _, ok := values[k]; ok {
+			return nil, false
+		}
+	}
+	return nil, true
+}
+
+// clientCertMatcher matches requests with a TLS client certificate.
+type clientCertMatcher struct{}
+
+func (m *clientCertMatcher) Match(request *http.Request) (*RouteMatch, bool) {
+	return nil, request.TLS != nil && len(request.TLS.PeerCertificates) > 0
+}
+
+// webSocketMatcher matches WebSocket handshake requests.
+type webSocketMatcher struct{}
+
+func (m *webSocketMatcher) Match(request *http.Request) (*RouteMatch, bool) {
+	if strings.ToLower(request.Header.Get("Upgrade")) != "websocket" {
+		return nil, false
+	}
+	for _, v := range request.Header[http.CanonicalHeaderKey("Connection")] {
+		for _, token := range strings.Split(v, ",") {
+			if strings.ToLower(strings.TrimSpace(token)) == "upgrade" {
+				return nil, true
+			}
+		}
+	}
+	return nil, false
+}
+
 // schemeMatcher matches the request against URL schemes.
 type schemeMatcher struct {
 	schemes []string
@@ -797,6 +1831,10 @@ type parsedTemplate struct {
 	VarsN []string
 	// Variable regexps (validators).
 	VarsR []*regexp.Regexp
+	// True if the template only matches the start of a value.
+	Prefix bool
+	// Formats accepted as an optional extension, see PathWithFormat.
+	Formats []string
 }
 
 // parseTemplate parses a route template, expanding variables into regexps.
@@ -868,6 +1906,13 @@ func parseTemplate(tpl *parsedTemplate, defaultPattern string, prefix bool,
 	// 5. Add the remaining.
 	raw = template[end:]
 	pattern.WriteString(regexp.QuoteMeta(raw))
+	if len(tpl.Formats) != 0 {
+		formats := make([]string, len(tpl.Formats))
+		for i, f := range tpl.Formats {
+			formats[i] = regexp.QuoteMeta(f)
+		}
+		fmt.Fprintf(pattern, `(?:\.(%s))?`, strings.Join(formats, "|"))
+	}
 	if redirectSlash {
 		pattern.WriteString("[/]?")
 	}
@@ -885,6 +1930,7 @@ func parseTemplate(tpl *parsedTemplate, defaultPattern string, prefix bool,
 	}
 	tpl.Regexp = reg
 	tpl.Reverse = reverse.String()
+	tpl.Prefix = prefix
 	return nil
 }
 
@@ -923,6 +1969,15 @@ func muxError(msg string, vars ...interface{}) error {
 	return errors.New(fmt.Sprintf(msg, vars...))
 }
 
+// stripPort returns host without its port, if any.
+func stripPort(host string) string {
+	// The colons of an IPv6 address are enclosed in brackets.
+	if i := strings.LastIndex(host, ":"); i > strings.LastIndex(host, "]") {
+		return host[:i]
+	}
+	return host
+}
+
 // cleanPath returns the canonical path for p, eliminating . and .. elements.
 //
 // Extracted from the http package.
@@ -955,6 +2010,28 @@ func stringMapFromPairs(err string, pairs ...string) map[string]string {
 	return m
 }
 
+// intersect returns the values of the first set that are in all the other
+// sets, or nil if there are no sets.
+func intersect(sets [][]string) []string {
+	if len(sets) == 0 {
+		return nil
+	}
+	values := make([]string, 0, len(sets[0]))
+	for _, v := range sets[0] {
+		in := true
+		for _, set := range sets[1:] {
+			if !matchInArray(set, v) {
+				in = false
+				break
+			}
+		}
+		if in {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
 // variableNames returns a copy of variable names for route templates.
 func variableNames(templates ...*parsedTemplate) *[]string {
 	names := make([]string, 0)
@@ -966,6 +2043,16 @@ func variableNames(templates ...*parsedTemplate) *[]string {
 	return &names
 }
 
+// requestHost returns the host the request was sent to. Server requests
+// usually only have it in the Host field, so it is used when the URL has no
+// host.
+func requestHost(req *http.Request) string {
+	if req.URL.Host != "" {
+		return req.URL.Host
+	}
+	return req.Host
+}
+
 // matchInArray returns true if the given string value is in the array.
 func matchInArray(arr []string, value string) bool {
 	for _, v := range arr {
@@ -6,7 +6,13 @@ package mux
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"http"
+	"io/ioutil"
+	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -253,6 +259,40 @@ var headerMatcherTests = []headerMatcherTest{
 	},
 }
 
+type headerExactMatcherTest struct {
+	matcher *headerExactMatcher
+	headers map[string][]string
+	result  bool
+}
+
+var headerExactMatcherTests = []headerExactMatcherTest{
+	{
+		matcher: &headerExactMatcher{map[string]string{"accept": "application/json"}},
+		headers: map[string][]string{"Accept": {"application/json"}},
+		result:  true,
+	},
+	{
+		matcher: &headerExactMatcher{map[string]string{"accept": "application/json"}},
+		headers: map[string][]string{"Accept": {"text/html", "application/json"}},
+		result:  false,
+	},
+	{
+		matcher: &headerExactMatcher{map[string]string{"accept": ""}},
+		headers: map[string][]string{"Accept": {"anything"}},
+		result:  true,
+	},
+	{
+		matcher: &headerExactMatcher{map[string]string{"accept": ""}},
+		headers: map[string][]string{"Accept": {"anything", "else"}},
+		result:  false,
+	},
+	{
+		matcher: &headerExactMatcher{map[string]string{"accept": "application/json"}},
+		headers: map[string][]string{},
+		result:  false,
+	},
+}
+
 type hostMatcherTest struct {
 	matcher *Route
 	url     string
@@ -440,6 +480,40 @@ func TestHeaderMatcher(t *testing.T) {
 	}
 }
 
+func TestHeaderExactMatcher(t *testing.T) {
+	for _, v := range headerExactMatcherTests {
+		request, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+		for key, values := range v.headers {
+			for _, value := range values {
+				request.Header.Add(key, value)
+			}
+		}
+		_, result := v.matcher.Match(request)
+		if result != v.result {
+			if v.result {
+				t.Errorf("%#v: should match %v.", v.matcher, request.Header)
+			} else {
+				t.Errorf("%#v: should not match %v.", v.matcher, request.Header)
+			}
+		}
+	}
+}
+
+func TestHeadersExactVersusHeaders(t *testing.T) {
+	request, _ := http.NewRequest("GET", "http://localhost:8080/", nil)
+	request.Header.Add("Accept", "text/html")
+	request.Header.Add("Accept", "application/json")
+
+	route := newRoute().Headers("Accept", "application/json")
+	if _, ok := route.Match(request); !ok {
+		t.Errorf("Headers() should match a value among multiple values.")
+	}
+	route = newRoute().HeadersExact("Accept", "application/json")
+	if _, ok := route.Match(request); ok {
+		t.Errorf("HeadersExact() should not match a value among multiple values.")
+	}
+}
+
 func TestHostMatcher(t *testing.T) {
 	for _, v := range hostMatcherTests {
 		request, _ := http.NewRequest("GET", v.url, nil)
@@ -589,6 +663,877 @@ func TestMatchedRouteName(t *testing.T) {
 	}
 }
 
+func TestGetRegexp(t *testing.T) {
+	router := new(Router)
+	tests := map[string]*Route{
+		`^/products/$`:         router.NewRoute().Path("/products/"),
+		`^/articles/([0-9]+)$`: router.NewRoute().Path("/articles/{id:[0-9]+}"),
+		`^/users/([^/]+)$`:     router.NewRoute().Path("/users/{name}"),
+		`^/static/`:            router.NewRoute().PathPrefix("/static/"),
+	}
+	for expected, route := range tests {
+		if regexp, err := route.GetPathRegexp(); err != nil || regexp != expected {
+			t.Errorf("Expected path regexp %q, got %q (error: %v).", expected, regexp, err)
+		}
+	}
+
+	route := router.NewRoute().Host("{subdomain}.domain.com")
+	expected := `^([^.]+)\.domain\.com$`
+	if regexp, err := route.GetHostRegexp(); err != nil || regexp != expected {
+		t.Errorf("Expected host regexp %q, got %q (error: %v).", expected, regexp, err)
+	}
+	if _, err := route.GetPathRegexp(); err == nil {
+		t.Errorf("Expected an error for a route without a path.")
+	}
+	if _, err := tests[`^/static/`].GetHostRegexp(); err == nil {
+		t.Errorf("Expected an error for a route without a host.")
+	}
+}
+
+func TestRoutePriority(t *testing.T) {
+	router := new(Router)
+	low := router.NewRoute().Path("/{page}")
+	high := router.NewRoute().Path("/about").Priority(1)
+
+	request, _ := http.NewRequest("GET", "http://www.domain.com/about", nil)
+	if rv, ok := router.Match(request); !ok || rv.Route != high {
+		t.Errorf("Expected the high priority route to match, got %+v.", rv)
+	}
+
+	// Equal priorities keep the registration order.
+	first := router.NewRoute().PathPrefix("/").Priority(2)
+	second := router.NewRoute().PathPrefix("/").Priority(2)
+	if rv, ok := router.Match(request); !ok || rv.Route != first {
+		t.Errorf("Expected the first route with priority 2 to match, got %+v.", rv)
+	}
+	// Raising the priority of an earlier route moves it ahead of later ones.
+	low.Priority(2)
+	expected := []*Route{low, first, second, high}
+	if len(router.Routes) != len(expected) {
+		t.Fatalf("Expected %d routes, got %d.", len(expected), len(router.Routes))
+	}
+	for i, route := range expected {
+		if router.Routes[i] != route {
+			t.Errorf("Route %d: expected %+v, got %+v.", i, route, router.Routes[i])
+		}
+	}
+
+	// The priority of an unregistered clone is applied when it is added.
+	router = new(Router)
+	route := router.NewRoute().Path("/a")
+	clone := route.Clone().Priority(1)
+	if len(router.Routes) != 1 {
+		t.Fatalf("Expected 1 route after Priority on a clone, got %d.", len(router.Routes))
+	}
+	router.AddRoute(clone)
+	if len(router.Routes) != 2 || router.Routes[0] != clone || router.Routes[1] != route {
+		t.Errorf("Expected the clone then the route, got %v.", router.Routes)
+	}
+}
+
+func TestMountServeMux(t *testing.T) {
+	legacy := http.NewServeMux()
+	legacy.HandleFunc("/users/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("users:" + r.URL.Path))
+	})
+	legacy.HandleFunc("/about", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("about:" + r.URL.Path))
+	})
+	router := new(Router)
+	router.NewRoute().PathPrefix("/legacy/").MountServeMux(legacy)
+
+	tests := map[string]string{
+		"http://www.domain.com/legacy/users/42": "users:/users/42",
+		"http://www.domain.com/legacy/about":    "about:/about",
+	}
+	for url, expected := range tests {
+		request, _ := http.NewRequest("GET", url, nil)
+		rsp := NewRecorder()
+		router.ServeHTTP(rsp, request)
+		if body := rsp.Body.String(); body != expected {
+			t.Errorf("%s: expected %q, got %q.", url, expected, body)
+		}
+		if request.URL.Path[:8] != "/legacy/" {
+			t.Errorf("%s: the original request was modified: %q.", url, request.URL.Path)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a panic for a route without a path prefix.")
+		}
+	}()
+	router.NewRoute().Path("/legacy/").MountServeMux(legacy)
+}
+
+func TestServeFiles(t *testing.T) {
+	router := new(Router)
+	route := router.ServeFiles("/static/", http.Dir("."))
+
+	request, _ := http.NewRequest("GET", "http://www.domain.com/static/doc.go", nil)
+	rsp := NewRecorder()
+	router.ServeHTTP(rsp, request)
+	if rsp.Code != http.StatusOK || !bytes.HasPrefix(rsp.Body.Bytes(), []byte("// Copyright")) {
+		t.Errorf("Expected doc.go to be served, got %d %q.", rsp.Code, rsp.Body.String())
+	}
+
+	// The router redirects to the clean path before any route is tested,
+	// so call the route handler directly.
+	request, _ = http.NewRequest("GET", "http://www.domain.com/static/../mux.go", nil)
+	rsp = NewRecorder()
+	route.handler.ServeHTTP(rsp, request)
+	if rsp.Code != http.StatusNotFound {
+		t.Errorf("Expected a traversal attempt to be rejected, got %d.", rsp.Code)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	called := false
+	router := new(Router)
+	router.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.Write([]byte("api"))
+	}).Methods("GET").CORS(CORSOptions{
+		AllowOrigin:  "http://www.domain.com",
+		AllowHeaders: []string{"X-Token"},
+	})
+
+	// Preflight request.
+	request, _ := http.NewRequest("OPTIONS", "http://api.domain.com/api", nil)
+	request.Header.Set("Origin", "http://www.domain.com")
+	request.Header.Set("Access-Control-Request-Method", "GET")
+	rsp := NewRecorder()
+	router.ServeHTTP(rsp, request)
+	if rsp.Code != http.StatusOK || called {
+		t.Errorf("Preflight: expected 200 without calling the handler, got %d.", rsp.Code)
+	}
+	expected := map[string]string{
+		"Access-Control-Allow-Origin":  "http://www.domain.com",
+		"Access-Control-Allow-Methods": "GET",
+		"Access-Control-Allow-Headers": "X-Token",
+	}
+	for k, v := range expected {
+		if got := rsp.HeaderMap.Get(k); got != v {
+			t.Errorf("Preflight: expected %s %q, got %q.", k, v, got)
+		}
+	}
+
+	// A plain OPTIONS request is still restricted by Methods.
+	request, _ = http.NewRequest("OPTIONS", "http://api.domain.com/api", nil)
+	if _, ok := router.Match(request); ok {
+		t.Errorf("Expected a plain OPTIONS request not to match.")
+	}
+
+	// Simple cross-origin request.
+	request, _ = http.NewRequest("GET", "http://api.domain.com/api", nil)
+	request.Header.Set("Origin", "http://www.domain.com")
+	rsp = NewRecorder()
+	router.ServeHTTP(rsp, request)
+	if !called || rsp.Body.String() != "api" {
+		t.Errorf("GET: expected the handler to be called, got %q.", rsp.Body.String())
+	}
+	if got := rsp.HeaderMap.Get("Access-Control-Allow-Origin"); got != "http://www.domain.com" {
+		t.Errorf("GET: expected Access-Control-Allow-Origin, got %q.", got)
+	}
+}
+
+func TestQueryAbsent(t *testing.T) {
+	router := new(Router)
+	route := router.NewRoute().Path("/products").QueryAbsent("format", "callback")
+
+	tests := map[string]bool{
+		"http://www.domain.com/products":                   true,
+		"http://www.domain.com/products?page=2":            true,
+		"http://www.domain.com/products?format=json":       false,
+		"http://www.domain.com/products?format=":           false,
+		"http://www.domain.com/products?page=2&callback=f": false,
+	}
+	for url, expected := range tests {
+		request, _ := http.NewRequest("GET", url, nil)
+		rv, ok := router.Match(request)
+		if ok != expected || (ok && rv.Route != route) {
+			t.Errorf("%s: expected match %v, got %v.", url, expected, ok)
+		}
+	}
+}
+
+func TestMatchedMethod(t *testing.T) {
+	var method string
+	router := new(Router)
+	router.HandleFunc("/products", func(w http.ResponseWriter, r *http.Request) {
+		method = MatchedMethod(r)
+	}).Methods("GET", "POST")
+	router.HandleFunc("/articles", func(w http.ResponseWriter, r *http.Request) {
+		method = MatchedMethod(r)
+	})
+
+	tests := map[string]string{
+		"GET /products":  "GET",
+		"POST /products": "POST",
+		"GET /articles":  "",
+	}
+	for test, expected := range tests {
+		parts := strings.Split(test, " ")
+		request, _ := http.NewRequest(parts[0], "http://www.domain.com"+parts[1], nil)
+		method = "unset"
+		router.ServeHTTP(NewRecorder(), request)
+		if method != expected {
+			t.Errorf("%s: expected matched method %q, got %q.", test, expected, method)
+		}
+	}
+}
+
+func TestRequireClientCert(t *testing.T) {
+	router := new(Router)
+	route := router.NewRoute().Path("/api").RequireClientCert()
+
+	tests := []struct {
+		state    *tls.ConnectionState
+		expected bool
+	}{
+		{nil, false},
+		{&tls.ConnectionState{}, false},
+		{&tls.ConnectionState{PeerCertificates: []*x509.Certificate{new(x509.Certificate)}}, true},
+	}
+	for _, test := range tests {
+		request, _ := http.NewRequest("GET", "https://www.domain.com/api", nil)
+		request.TLS = test.state
+		rv, ok := router.Match(request)
+		if ok != test.expected || (ok && rv.Route != route) {
+			t.Errorf("TLS state %+v: expected match %v, got %v.", test.state, test.expected, ok)
+		}
+	}
+}
+
+func TestMethodOverride(t *testing.T) {
+	var method string
+	router := new(Router).UseMethodOverride()
+	router.HandleFunc("/products/1", func(w http.ResponseWriter, r *http.Request) {
+		method = "PUT"
+	}).Methods("PUT")
+	router.HandleFunc("/products/1", func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+	})
+
+	tests := []struct {
+		method   string
+		header   string
+		body     string
+		expected string
+	}{
+		{"POST", "PUT", "", "PUT"},
+		{"POST", "", "_method=put", "PUT"},
+		{"POST", "", "", "POST"},
+		{"GET", "PUT", "", "GET"},
+	}
+	for _, test := range tests {
+		request, _ := http.NewRequest(test.method, "http://www.domain.com/products/1",
+			strings.NewReader(test.body))
+		if test.header != "" {
+			request.Header.Set("X-HTTP-Method-Override", test.header)
+		}
+		if test.body != "" {
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		method = ""
+		router.ServeHTTP(NewRecorder(), request)
+		if method != test.expected {
+			t.Errorf("%s with override %q %q: expected %s, got %s.",
+				test.method, test.header, test.body, test.expected, method)
+		}
+	}
+
+	// Bodies that aren't url-encoded forms are left for the handler.
+	var body string
+	router = new(Router).UseMethodOverride()
+	router.HandleFunc("/products", func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		method, body = r.Method, string(b)
+	})
+	request, _ := http.NewRequest("POST", "http://www.domain.com/products",
+		strings.NewReader(`{"_method": "PUT"}`))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(NewRecorder(), request)
+	if method != "POST" || body != `{"_method": "PUT"}` {
+		t.Errorf("JSON body: expected POST with the body, got %s with %q.", method, body)
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	router := new(Router)
+	route := router.NewRoute().Path("/ws").WebSocket()
+
+	tests := []struct {
+		connection string
+		upgrade    string
+		expected   bool
+	}{
+		{"Upgrade", "websocket", true},
+		{"keep-alive, Upgrade", "WebSocket", true},
+		{"", "", false},
+		{"keep-alive", "websocket", false},
+		{"Upgrade", "h2c", false},
+	}
+	for _, test := range tests {
+		request, _ := http.NewRequest("GET", "http://www.domain.com/ws", nil)
+		if test.connection != "" {
+			request.Header.Set("Connection", test.connection)
+		}
+		if test.upgrade != "" {
+			request.Header.Set("Upgrade", test.upgrade)
+		}
+		rv, ok := router.Match(request)
+		if ok != test.expected || (ok && rv.Route != route) {
+			t.Errorf("Connection %q, Upgrade %q: expected match %v, got %v.",
+				test.connection, test.upgrade, test.expected, ok)
+		}
+	}
+}
+
+func TestMaxBodySize(t *testing.T) {
+	var body []byte
+	var readErr error
+	router := new(Router)
+	router.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		body, readErr = ioutil.ReadAll(r.Body)
+	}).MaxBodySize(5)
+
+	tests := []struct {
+		body          string
+		contentLength int64
+		code          int
+		readErr       bool
+	}{
+		{"12345", 5, http.StatusOK, false},
+		{"123456", 6, http.StatusRequestEntityTooLarge, false},
+		{"12345", -1, http.StatusOK, false},
+		{"123456", -1, http.StatusOK, true},
+	}
+	for _, test := range tests {
+		request, _ := http.NewRequest("POST", "http://www.domain.com/upload",
+			strings.NewReader(test.body))
+		request.ContentLength = test.contentLength
+		body, readErr = nil, nil
+		rsp := NewRecorder()
+		router.ServeHTTP(rsp, request)
+		if rsp.Code == 0 {
+			rsp.Code = http.StatusOK
+		}
+		if rsp.Code != test.code {
+			t.Errorf("%q (length %d): expected status %d, got %d.",
+				test.body, test.contentLength, test.code, rsp.Code)
+		}
+		if (readErr != nil) != test.readErr {
+			t.Errorf("%q (length %d): expected read error %v, got %v.",
+				test.body, test.contentLength, test.readErr, readErr)
+		}
+		if test.code == http.StatusOK && !test.readErr && string(body) != test.body {
+			t.Errorf("%q (length %d): handler read %q.", test.body, test.contentLength, body)
+		}
+	}
+}
+
+func TestHostExact(t *testing.T) {
+	router := new(Router)
+	route := router.NewRoute().HostExact("www.Domain.com")
+
+	tests := map[string]bool{
+		"http://www.domain.com/":      true,
+		"http://WWW.DOMAIN.COM/":      true,
+		"http://www.domain.com:8080/": true,
+		"http://api.domain.com/":      false,
+		"http://www.domain.com.br/":   false,
+	}
+	for url, expected := range tests {
+		request, _ := http.NewRequest("GET", url, nil)
+		rv, ok := router.Match(request)
+		if ok != expected || (ok && rv.Route != route) {
+			t.Errorf("%s: expected match %v, got %v.", url, expected, ok)
+		}
+	}
+}
+
+func TestPathWithFormat(t *testing.T) {
+	router := new(Router)
+	route := router.NewRoute().PathWithFormat("/users/{id}", "json", "xml")
+
+	tests := map[string]string{
+		"http://www.domain.com/users/42":      "",
+		"http://www.domain.com/users/42.json": "json",
+		"http://www.domain.com/users/42.xml":  "xml",
+	}
+	for url, format := range tests {
+		request, _ := http.NewRequest("GET", url, nil)
+		rv, ok := router.Match(request)
+		if !ok || rv.Route != route {
+			t.Errorf("%s: expected a match.", url)
+			continue
+		}
+		vars := Vars(request)
+		if vars["id"] != "42" || vars["format"] != format {
+			t.Errorf("%s: expected id 42 and format %q, got %v.", url, format, vars)
+		}
+	}
+
+	// Other extensions are part of the variable.
+	request, _ := http.NewRequest("GET", "http://www.domain.com/users/42.yaml", nil)
+	if _, ok := router.Match(request); !ok || Vars(request)["id"] != "42.yaml" {
+		t.Errorf("Expected id 42.yaml, got %v.", Vars(request))
+	}
+
+	if u := route.URL("id", "42", "format", "xml"); u.String() != "/users/42.xml" {
+		t.Errorf("Expected /users/42.xml, got %v.", u)
+	}
+	if u := route.URL("id", "42"); u.String() != "/users/42" {
+		t.Errorf("Expected /users/42, got %v.", u)
+	}
+	if _, err := route.URLDebug("id", "42", "format", "yaml"); err == nil {
+		t.Errorf("Expected an error for an unknown format.")
+	}
+}
+
+func TestTryHandle(t *testing.T) {
+	router := new(Router)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, path := range []string{"/{id", "/{id:[}", "/{:[0-9]+}", "products"} {
+		if route, err := router.TryHandle(path, handler); err == nil || route != nil {
+			t.Errorf("%q: expected an error, got route %v.", path, route)
+		}
+	}
+	if len(router.Routes) != 0 {
+		t.Errorf("Expected no routes, got %d.", len(router.Routes))
+	}
+
+	route, err := router.TryHandle("/products/{id:[0-9]+}", handler)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v.", err)
+	}
+	request, _ := http.NewRequest("GET", "http://www.domain.com/products/42", nil)
+	if rv, ok := router.Match(request); !ok || rv.Route != route || Vars(request)["id"] != "42" {
+		t.Errorf("Expected the products route to match, got %v.", rv)
+	}
+
+	if _, err := router.NewRoute().TryHost("{sub.domain.com"); err == nil {
+		t.Errorf("Expected an error for a malformed host.")
+	}
+	if r, err := router.NewRoute().TryHost("{sub}.domain.com"); err != nil || r == nil {
+		t.Errorf("Unexpected error: %v.", err)
+	}
+}
+
+func TestTryRuntimePanic(t *testing.T) {
+	defer func() {
+		if _, ok := recover().(runtime.Error); !ok {
+			t.Errorf("Expected a runtime error to keep panicking.")
+		}
+	}()
+	var route *Route
+	route.TryPath("/products")
+	t.Errorf("Expected TryPath on a nil route to panic.")
+}
+
+// methodTestRouter returns a router with n routes split across methods,
+// with a few routes accepting any method.
+func methodTestRouter(n int) *Router {
+	router := new(Router)
+	methods := []string{"GET", "POST", "PUT", "DELETE"}
+	for i := 0; i < n; i++ {
+		route := router.NewRoute().Path(fmt.Sprintf("/r%d/{id}", i))
+		if i%10 != 0 {
+			route.Methods(methods[i%len(methods)])
+		}
+	}
+	return router
+}
+
+func TestMatchByMethod(t *testing.T) {
+	router := methodTestRouter(40)
+	router.NewRoute().Path("/cors").Methods("GET").
+		CORS(CORSOptions{AllowOrigin: "http://www.domain.com"})
+	router.NewRoute().Path("/{any}").Methods("GET", "PUT").Priority(-1)
+
+	// linearMatch is how Router.Match tested routes before partitioning.
+	linearMatch := func(request *http.Request) *Route {
+		for _, route := range router.Routes {
+			if _, ok := route.Match(request); ok {
+				return route
+			}
+		}
+		return nil
+	}
+	for _, method := range []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"} {
+		for _, path := range []string{"/r0/1", "/r1/1", "/r2/1", "/r3/1", "/r13/1", "/r30/1", "/cors", "/other"} {
+			request, _ := http.NewRequest(method, "http://www.domain.com"+path, nil)
+			request.Header.Set("Access-Control-Request-Method", "GET")
+			want := linearMatch(request)
+			var got *Route
+			if rv, ok := router.Match(request); ok {
+				got = rv.Route
+			}
+			if got != want {
+				t.Errorf("%s %s: got route %p, want %p.", method, path, got, want)
+			}
+		}
+	}
+
+	// Matchers added after the first match are taken into account.
+	route := router.NewRoute().Path("/late")
+	request, _ := http.NewRequest("POST", "http://www.domain.com/late", nil)
+	if rv, ok := router.Match(request); !ok || rv.Route != route {
+		t.Errorf("Expected the late route to match.")
+	}
+	route.Methods("GET")
+	if rv, ok := router.Match(request); ok && rv.Route == route {
+		t.Errorf("Expected the late route not to match a POST request.")
+	}
+
+	// So are routes appended to Routes directly, or replaced by assigning a
+	// new slice.
+	direct := newRoute().Path("/direct/route")
+	router.Routes = append(router.Routes, direct)
+	request, _ = http.NewRequest("POST", "http://www.domain.com/direct/route", nil)
+	if rv, ok := router.Match(request); !ok || rv.Route != direct {
+		t.Errorf("Expected the appended route to match.")
+	}
+	replaced := newRoute().Path("/direct/route").Methods("PUT")
+	routes := make([]*Route, len(router.Routes))
+	copy(routes, router.Routes)
+	routes[len(routes)-1] = replaced
+	router.Routes = routes
+	if rv, ok := router.Match(request); ok && rv.Route == direct {
+		t.Errorf("Expected the replaced route not to match.")
+	}
+	request, _ = http.NewRequest("PUT", "http://www.domain.com/direct/route", nil)
+	if rv, ok := router.Match(request); !ok || rv.Route != replaced {
+		t.Errorf("Expected the replacing route to match a PUT request.")
+	}
+	router.Routes = router.Routes[:len(router.Routes)-1]
+	if rv, ok := router.Match(request); ok && rv.Route == replaced {
+		t.Errorf("Expected the removed route not to match.")
+	}
+}
+
+func BenchmarkMatchLinear(b *testing.B) {
+	router := methodTestRouter(200)
+	request, _ := http.NewRequest("DELETE", "http://www.domain.com/r199/1", nil)
+	for i := 0; i < b.N; i++ {
+		for _, route := range router.Routes {
+			if _, ok := route.Match(request); ok {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkMatchByMethod(b *testing.B) {
+	router := methodTestRouter(200)
+	request, _ := http.NewRequest("DELETE", "http://www.domain.com/r199/1", nil)
+	for i := 0; i < b.N; i++ {
+		router.Match(request)
+	}
+}
+
+func TestMatcherVars(t *testing.T) {
+	router := new(Router)
+	subject := func(req *http.Request, vars map[string]string) bool {
+		auth := req.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return false
+		}
+		vars["subject"] = auth[len("Bearer "):]
+		// Path variables take precedence.
+		vars["id"] = "ignored"
+		return true
+	}
+	route := router.NewRoute().Path("/items/{id}").MatcherVars(subject)
+
+	request, _ := http.NewRequest("GET", "http://localhost/items/42", nil)
+	request.Header.Set("Authorization", "Bearer alice")
+	rv, ok := router.Match(request)
+	if !ok || rv.Route != route {
+		t.Fatalf("Expected a match.")
+	}
+	vars := Vars(request)
+	if vars["subject"] != "alice" || vars["id"] != "42" {
+		t.Errorf("Expected subject alice and id 42, got %v.", vars)
+	}
+
+	request, _ = http.NewRequest("GET", "http://localhost/items/42", nil)
+	if _, ok := router.Match(request); ok {
+		t.Errorf("Expected no match without the Authorization header.")
+	}
+
+	// The variables aren't set if another matcher fails.
+	router = new(Router)
+	router.NewRoute().MatcherVars(subject).Methods("POST")
+	request, _ = http.NewRequest("GET", "http://localhost/", nil)
+	request.Header.Set("Authorization", "Bearer bob")
+	if _, ok := router.Match(request); ok {
+		t.Errorf("Expected no match for GET.")
+	}
+	if _, ok := Vars(request)["subject"]; ok {
+		t.Errorf("Expected no subject, got %v.", Vars(request))
+	}
+}
+
+func TestDefaultHeaders(t *testing.T) {
+	router := new(Router)
+	router.DefaultHeaders("X-Content-Type-Options", "nosniff",
+		"Cache-Control", "no-cache")
+	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	router.HandleFunc("/cached", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("ok"))
+	})
+
+	request, _ := http.NewRequest("GET", "http://localhost/", nil)
+	rec := NewRecorder()
+	router.ServeHTTP(rec, request)
+	if v := rec.HeaderMap.Get("X-Content-Type-Options"); v != "nosniff" {
+		t.Errorf("Expected X-Content-Type-Options nosniff, got %q.", v)
+	}
+	if v := rec.HeaderMap.Get("Cache-Control"); v != "no-cache" {
+		t.Errorf("Expected Cache-Control no-cache, got %q.", v)
+	}
+
+	// Handlers can override them.
+	request, _ = http.NewRequest("GET", "http://localhost/cached", nil)
+	rec = NewRecorder()
+	router.ServeHTTP(rec, request)
+	if v := rec.HeaderMap.Get("Cache-Control"); v != "max-age=60" {
+		t.Errorf("Expected Cache-Control max-age=60, got %q.", v)
+	}
+	if v := rec.HeaderMap.Get("X-Content-Type-Options"); v != "nosniff" {
+		t.Errorf("Expected X-Content-Type-Options nosniff, got %q.", v)
+	}
+}
+
+func TestRouteMeta(t *testing.T) {
+	router := new(Router)
+	route := router.HandleFunc("/users/{id}", nil).
+		Meta("summary", "Get a user").
+		Meta("tags", []string{"users"})
+
+	if v := route.GetMeta("summary"); v != "Get a user" {
+		t.Errorf("Expected summary %q, got %v.", "Get a user", v)
+	}
+	if v, ok := route.GetMeta("tags").([]string); !ok || len(v) != 1 || v[0] != "users" {
+		t.Errorf("Expected tags [users], got %v.", route.GetMeta("tags"))
+	}
+	if v := route.GetMeta("missing"); v != nil {
+		t.Errorf("Expected nil, got %v.", v)
+	}
+	if v := router.NewRoute().GetMeta("summary"); v != nil {
+		t.Errorf("Expected nil, got %v.", v)
+	}
+
+	// Metadata doesn't affect matching.
+	request, _ := http.NewRequest("GET", "http://localhost/users/42", nil)
+	if rv, ok := router.Match(request); !ok || rv.Route != route {
+		t.Errorf("Expected a match.")
+	}
+
+	// Clones get their own copy.
+	clone := route.Clone().Meta("summary", "Clone")
+	if v := route.GetMeta("summary"); v != "Get a user" {
+		t.Errorf("Expected summary %q, got %v.", "Get a user", v)
+	}
+	if v := clone.GetMeta("summary"); v != "Clone" {
+		t.Errorf("Expected summary %q, got %v.", "Clone", v)
+	}
+}
+
+func TestGetMethodsAndSchemes(t *testing.T) {
+	router := new(Router)
+	tests := []struct {
+		route   *Route
+		methods []string
+		schemes []string
+	}{
+		{router.NewRoute().Path("/"), nil, nil},
+		{router.NewRoute().Methods("get", "POST"), []string{"GET", "POST"}, nil},
+		{router.NewRoute().Schemes("HTTPS"), nil, []string{"https"}},
+		{router.NewRoute().Methods("GET", "PUT").Schemes("http", "https").Methods("PUT"),
+			[]string{"PUT"}, []string{"http", "https"}},
+	}
+	for i, test := range tests {
+		if got := test.route.GetMethods(); fmt.Sprint(got) != fmt.Sprint(test.methods) || (got == nil) != (test.methods == nil) {
+			t.Errorf("%d: Expected methods %v, got %v.", i, test.methods, got)
+		}
+		if got := test.route.GetSchemes(); fmt.Sprint(got) != fmt.Sprint(test.schemes) || (got == nil) != (test.schemes == nil) {
+			t.Errorf("%d: Expected schemes %v, got %v.", i, test.schemes, got)
+		}
+	}
+}
+
+func TestRequestHost(t *testing.T) {
+	router := new(Router)
+	route := router.NewRoute().Host("{subdomain}.domain.com")
+	exact := router.NewRoute().HostExact("api.example.com")
+
+	request, _ := http.NewRequest("GET", "http://localhost/", nil)
+	request.URL.Host = ""
+	request.Host = "www.domain.com"
+	if rv, ok := router.Match(request); !ok || rv.Route != route {
+		t.Fatalf("Expected a match for Host %q.", request.Host)
+	}
+	if v := Vars(request)["subdomain"]; v != "www" {
+		t.Errorf("Expected subdomain www, got %q.", v)
+	}
+
+	request.Host = "api.example.com:8080"
+	if rv, ok := router.Match(request); !ok || rv.Route != exact {
+		t.Errorf("Expected a match for Host %q.", request.Host)
+	}
+
+	// The URL host takes precedence.
+	request.URL.Host = "other.org"
+	request.Host = "www.domain.com"
+	if _, ok := router.Match(request); ok {
+		t.Errorf("Expected no match for URL host %q.", request.URL.Host)
+	}
+}
+
+func TestRouterTestMatch(t *testing.T) {
+	router := new(Router)
+	router.NewRoute().Host("{user}.domain.com").Path("/").Name("profile")
+	router.HandleFunc("/", nil).Name("home")
+	router.HandleFunc("/articles/{id:[0-9]+}", nil).Methods("GET").Name("article")
+	router.HandleFunc("/articles/{id:[0-9]+}", nil).Methods("PUT", "DELETE")
+
+	tests := []struct {
+		method, url string
+		name        string
+		vars        map[string]string
+		ok          bool
+	}{
+		{"GET", "http://localhost/", "home", map[string]string{}, true},
+		{"GET", "http://localhost/articles/42", "article", map[string]string{"id": "42"}, true},
+		{"PUT", "http://localhost/articles/42", "", map[string]string{"id": "42"}, true},
+		{"GET", "http://joe.domain.com/", "profile", map[string]string{"user": "joe"}, true},
+		{"POST", "http://localhost/articles/42", "", nil, false},
+		{"GET", "http://localhost/articles/abc", "", nil, false},
+		{"GET", "%zz", "", nil, false},
+	}
+	for _, test := range tests {
+		name, vars, ok := router.TestMatch(test.method, test.url)
+		if ok != test.ok || name != test.name || fmt.Sprint(vars) != fmt.Sprint(test.vars) {
+			t.Errorf("%s %s: Expected %q, %v, %v, got %q, %v, %v.", test.method, test.url,
+				test.name, test.vars, test.ok, name, vars, ok)
+		}
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	newRouter := func() *Router {
+		router := new(Router)
+		handler := func(w http.ResponseWriter, r *http.Request) {}
+		router.HandleFunc("/articles", handler).Methods("GET")
+		router.HandleFunc("/articles", handler).Methods("PUT", "GET")
+		router.HandleFunc("/users", handler).Methods("DELETE")
+		return router
+	}
+
+	router := newRouter()
+	request, _ := http.NewRequest("POST", "http://www.domain.com/articles", nil)
+	rsp := NewRecorder()
+	router.ServeHTTP(rsp, request)
+	if rsp.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d.", rsp.Code)
+	}
+	if allow := rsp.HeaderMap.Get("Allow"); allow != "GET, PUT" {
+		t.Errorf("Expected Allow %q, got %q.", "GET, PUT", allow)
+	}
+
+	// Paths that no route matches are still not found.
+	request, _ = http.NewRequest("POST", "http://www.domain.com/other", nil)
+	rsp = NewRecorder()
+	router.ServeHTTP(rsp, request)
+	if rsp.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d.", rsp.Code)
+	}
+
+	// 405 takes precedence over the not found route.
+	router.NotFoundRoute().HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	})
+	request, _ = http.NewRequest("POST", "http://www.domain.com/articles", nil)
+	rsp = NewRecorder()
+	router.ServeHTTP(rsp, request)
+	if rsp.Code != http.StatusMethodNotAllowed {
+		t.Errorf("With a not found route: expected status 405, got %d.", rsp.Code)
+	}
+
+	// And over the not found handler.
+	router = newRouter()
+	router.NotFoundHandler = http.NotFoundHandler()
+	request, _ = http.NewRequest("POST", "http://www.domain.com/articles", nil)
+	rsp = NewRecorder()
+	router.ServeHTTP(rsp, request)
+	if rsp.Code != http.StatusMethodNotAllowed {
+		t.Errorf("With a not found handler: expected status 405, got %d.", rsp.Code)
+	}
+
+	// Routes of subrouters are taken into account.
+	router = newRouter()
+	subrouter := router.NewRoute().PathPrefix("/admin").Methods("GET", "PUT", "POST").NewRouter()
+	subrouter.HandleFunc("/admin/users", func(w http.ResponseWriter, r *http.Request) {}).Methods("PUT", "DELETE")
+	request, _ = http.NewRequest("GET", "http://www.domain.com/admin/users", nil)
+	rsp = NewRecorder()
+	router.ServeHTTP(rsp, request)
+	if rsp.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Subrouter: expected status 405, got %d.", rsp.Code)
+	}
+	if allow := rsp.HeaderMap.Get("Allow"); allow != "PUT" {
+		t.Errorf("Subrouter: expected Allow %q, got %q.", "PUT", allow)
+	}
+	request, _ = http.NewRequest("GET", "http://www.domain.com/admin/other", nil)
+	rsp = NewRecorder()
+	router.ServeHTTP(rsp, request)
+	if rsp.Code != http.StatusNotFound {
+		t.Errorf("Subrouter: expected status 404, got %d.", rsp.Code)
+	}
+
+	router = newRouter().HandleMethodNotAllowed(false)
+	request, _ = http.NewRequest("POST", "http://www.domain.com/articles", nil)
+	rsp = NewRecorder()
+	router.ServeHTTP(rsp, request)
+	if rsp.Code != http.StatusNotFound {
+		t.Errorf("Disabled: expected status 404, got %d.", rsp.Code)
+	}
+	if allow := rsp.HeaderMap.Get("Allow"); allow != "" {
+		t.Errorf("Disabled: expected no Allow header, got %q.", allow)
+	}
+}
+
+func TestNotFoundRoute(t *testing.T) {
+	router := new(Router)
+	notFound := router.NotFoundRoute()
+	// Registered after the not found route, but still tested first.
+	route := router.NewRoute().Path("/products/")
+
+	if router.NotFoundRoute() != notFound {
+		t.Errorf("Expected the same not found route.")
+	}
+
+	request, _ := http.NewRequest("GET", "http://www.domain.com/products/", nil)
+	rv, ok := router.Match(request)
+	if !ok || rv.Route != route {
+		t.Errorf("Expected the products route, got %+v.", rv)
+	}
+
+	request, _ = http.NewRequest("GET", "http://www.domain.com/articles/", nil)
+	rv, ok = router.Match(request)
+	if !ok || rv.Route != notFound {
+		t.Errorf("Expected the not found route, got %+v.", rv)
+	}
+	if CurrentRoute(request) != notFound {
+		t.Errorf("Expected the not found route as current route.")
+	}
+}
+
 func TestSubRouting(t *testing.T) {
 	// Example from docs.
 	router := new(Router)
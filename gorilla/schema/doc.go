@@ -27,7 +27,9 @@ will be of type url.Values: http.Request.Form or http.Request.MultipartForm.
 
 The supported field types in the destination struct are:
 
-- bool;
+- bool: "1", "t", "true" and "on" are true, and "0", "f", "false" and "off"
+  are false, in any case. An unchecked HTML checkbox isn't submitted at all,
+  so its field keeps its zero value, false;
 
 - float variants (float32, float64);
 
@@ -48,6 +50,16 @@ above types;
 
 Non-supported types are simply ignored.
 
+Keys that don't map to a struct field are ignored too, so forms can carry
+extra values like CSRF tokens. To report them as errors instead, and catch
+typos in field names, use LoadStrict().
+
+Keys must match field names exactly. To match them ignoring case, so that
+"name" fills the field Name, use LoadWithOptions() with IgnoreCase set:
+
+	err := schema.LoadWithOptions(person, r.Form,
+		schema.LoadOptions{IgnoreCase: true})
+
 Nested structs are scanned recursivelly and the source keys must use dotted
 notation for that. So for example, when filling the struct Person below:
 
@@ -118,5 +130,33 @@ the struct:
 	}
 
 As you see, not everybody is good at rocket science!
+
+The inverse operation, getting the form values of a struct to build a query
+string or pre-fill a form, is done by Encode():
+
+	values, err := schema.Encode(person)
+
+A field of a basic type can define a default value, used when its key is
+absent from the source map, with the "schema-default" tag:
+
+	type Search struct {
+		Query string
+		Page  int `schema-default:"1"`
+	}
+
+A default that can't be converted to the field type is reported as an error
+by Load.
+
+A field of a basic type, or a slice of them, can be required with the
+"required" tag in "schema-tags". Load reports an error for it if its key is
+absent from the source map or only has empty values:
+
+	type Signup struct {
+		Email string `schema-tags:"required"`
+	}
+
+To protect against malicious input, keys can have at most MaxDepth dotted
+parts and slices are loaded with at most MaxSliceLen values. Load reports an
+error for the keys over these limits.
 */
 package schema
@@ -0,0 +1,111 @@
+// Copyright 2011 Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package schema
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"url"
+)
+
+// Encode returns the form values of a struct. It is the inverse of Load.
+//
+// The parameter must be a struct or a pointer to a struct. Fields are
+// encoded under the keys Load reads them from: nested structs and maps use
+// dotted keys, and slices have one value per element, so a slice of structs
+// has one value per element for each field of the struct. Nil pointers and
+// non-supported types are skipped.
+//
+// This is useful to build query strings or to pre-fill forms.
+func Encode(i interface{}) (url.Values, error) {
+	rv := reflect.Indirect(reflect.ValueOf(i))
+	if rv.Kind() != reflect.Struct {
+		return nil, errors.New("Interface must be a struct or a pointer to struct.")
+	}
+	values := make(url.Values)
+	if err := encodeStruct(rv, "", values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// encodeStruct adds the values of the fields of a struct to dst.
+//
+// - prefix is the dotted path of rv in dst keys.
+func encodeStruct(rv reflect.Value, prefix string, dst url.Values) error {
+	spec, err := defaultStructMap.getOrLoad(rv.Type())
+	if err != nil {
+		return err
+	}
+	for _, fieldSpec := range spec.fields {
+		field := indirect(rv.FieldByName(fieldSpec.realName))
+		key := prefix + fieldSpec.name
+		switch field.Kind() {
+		case reflect.Invalid:
+			// A nil pointer.
+		case reflect.Struct:
+			if err := encodeStruct(field, key+".", dst); err != nil {
+				return err
+			}
+		case reflect.Slice:
+			for i := 0; i < field.Len(); i++ {
+				elem := indirect(field.Index(i))
+				if elem.Kind() == reflect.Struct {
+					if err := encodeStruct(elem, key+".", dst); err != nil {
+						return err
+					}
+				} else if s, ok := encodeBasic(elem); ok {
+					dst.Add(key, s)
+				}
+			}
+		case reflect.Map:
+			for _, k := range field.MapKeys() {
+				if s, ok := encodeBasic(field.MapIndex(k)); ok {
+					dst.Add(key+"."+k.String(), s)
+				}
+			}
+		default:
+			if s, ok := encodeBasic(field); ok {
+				dst.Add(key, s)
+			}
+		}
+	}
+	return nil
+}
+
+// encodeBasic converts a value of a basic type to a string. It returns
+// false for other types.
+func encodeBasic(v reflect.Value) (string, bool) {
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.Btoa(v.Bool()), true
+	case reflect.Float32:
+		return strconv.Ftoa32(float32(v.Float()), 'g', -1), true
+	case reflect.Float64:
+		return strconv.Ftoa64(v.Float(), 'g', -1), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		return strconv.Itoa64(v.Int()), true
+	case reflect.String:
+		return v.String(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		return strconv.Uitoa64(v.Uint()), true
+	}
+	return "", false
+}
+
+// indirect resolves pointers to a value. It returns the zero Value if a nil
+// pointer is found.
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
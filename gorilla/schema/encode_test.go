@@ -0,0 +1,75 @@
+// Copyright 2011 Gorilla Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package schema
+
+import (
+	"reflect"
+	"testing"
+)
+
+type TestStruct12 struct {
+	Name    string `schema-name:"name"`
+	Age     *int
+	Nick    *string
+	Admin   bool
+	Score   float64
+	Tags    []string
+	Phones  []TestStruct13
+	Address TestStruct13
+	Counts  map[string]uint
+}
+
+type TestStruct13 struct {
+	Label  string
+	Number string
+}
+
+func TestEncode(t *testing.T) {
+	age := 42
+	src := &TestStruct12{
+		Name:   "John",
+		Age:    &age,
+		Admin:  true,
+		Score:  1.5,
+		Tags:   []string{"a", "b"},
+		Phones: []TestStruct13{{"home", "1"}, {"work", "2"}},
+		Address: TestStruct13{
+			Label: "main",
+		},
+		Counts: map[string]uint{"x": 7},
+	}
+	values, err := Encode(src)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := map[string][]string{
+		"name":           {"John"},
+		"Age":            {"42"},
+		"Admin":          {"true"},
+		"Score":          {"1.5"},
+		"Tags":           {"a", "b"},
+		"Phones.Label":   {"home", "work"},
+		"Phones.Number":  {"1", "2"},
+		"Address.Label":  {"main"},
+		"Address.Number": {""},
+		"Counts.x":       {"7"},
+	}
+	if !reflect.DeepEqual(map[string][]string(values), expected) {
+		t.Errorf("Expected %v, got %v", expected, values)
+	}
+
+	// Loading the values back gives the original struct.
+	dst := &TestStruct12{}
+	if err := Load(dst, values); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(dst, src) {
+		t.Errorf("Expected %+v, got %+v", src, dst)
+	}
+
+	if _, err := Encode("not a struct"); err == nil {
+		t.Errorf("Expected an error for a string")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -27,6 +28,9 @@ type TypeConv func(basic reflect.Value) reflect.Value
 // to the dynamic type
 var conversionMap = make(map[string]TypeConv)
 
+// Guards conversionMap.
+var conversionMutex sync.RWMutex
+
 // AddTypeConverter adds a function to perform conversions from the basic type
 // to a more complex type.
 //
@@ -48,12 +52,20 @@ var conversionMap = make(map[string]TypeConv)
 // And register that function for later use
 // AddTypeConverter(reflect.TypeOf(TString("")), ConvStringToTString)
 // Then the Load function can resolve the conversion.
+//
+// It is safe to call AddTypeConverter while values are loaded, but
+// converters should be added in an init function: a value loaded before its
+// converter is added fails to convert.
 func AddTypeConverter(rt reflect.Type, conv TypeConv) {
+	conversionMutex.Lock()
 	conversionMap[getTypeId(rt)] = conv
+	conversionMutex.Unlock()
 }
 
 // getTypeConverter returns a converter for a value, or nil if there are none.
 func getTypeConverter(rt reflect.Type) TypeConv {
+	conversionMutex.RLock()
+	defer conversionMutex.RUnlock()
 	if conv, ok := conversionMap[getTypeId(rt)]; ok {
 		return conv
 	}
@@ -151,6 +163,19 @@ func (e *SchemaError) New() string {
 	}
 	return fmt.Sprintf("%v", e.err)
 }
+// ----------------------------------------------------------------------------
+// Limits
+// ----------------------------------------------------------------------------
+
+// Limits on the source map, to protect against malicious input. Keys and
+// values over the limits are not loaded and Load reports an error for them.
+var (
+	// MaxDepth is the maximum number of dotted parts in a key.
+	MaxDepth = 16
+	// MaxSliceLen is the maximum number of values loaded into a slice.
+	MaxSliceLen = 1000
+)
+
 // ----------------------------------------------------------------------------
 // Load, Validate and variants
 // ----------------------------------------------------------------------------
@@ -164,23 +189,70 @@ func (e *SchemaError) New() string {
 // keys as "paths" in dotted notation.
 //
 // See the package documentation for a full explanation of the mechanics.
+//
+// Keys that don't map to a struct field are ignored, which allows forms to
+// carry extra values like CSRF tokens. Use LoadStrict to report them.
 func Load(i interface{}, data map[string][]string) error {
-	return loadAndValidate(i, data, nil, nil)
+	return loadAndValidate(i, data, nil, nil, LoadOptions{})
+}
+
+// LoadStrict is like Load, but it also reports an error for each key that
+// doesn't map to a struct field, to catch typos in form field names. The
+// other values are still loaded.
+func LoadStrict(i interface{}, data map[string][]string) error {
+	return loadAndValidate(i, data, nil, nil, LoadOptions{Strict: true})
+}
+
+// LoadOptions are the options for LoadWithOptions.
+type LoadOptions struct {
+	// Strict reports an error for each key that doesn't map to a struct
+	// field, as LoadStrict does.
+	Strict bool
+
+	// IgnoreCase matches keys to field names, or to their "schema-name"
+	// tags, ignoring case. A key that matches a field exactly is loaded
+	// into it. Otherwise, if several fields only differ by case, the first
+	// one in the struct is used. If a struct is filled by keys that only
+	// differ by case, such as "name" and "Name", the exact one is used;
+	// if none is exact, such as "NAME" and "name", their values are merged
+	// in the sorted order of the keys.
+	IgnoreCase bool
+}
+
+// LoadWithOptions is like Load, with options.
+func LoadWithOptions(i interface{}, data map[string][]string,
+	opts LoadOptions) error {
+	return loadAndValidate(i, data, nil, nil, opts)
 }
 
 // not public yet, but will be once filters and validators are implemented.
 func loadAndValidate(i interface{}, data map[string][]string,
-	filters map[string]string, validators map[string]string) error {
+	filters map[string]string, validators map[string]string,
+	opts LoadOptions) error {
 	err := &SchemaError{}
 	val := reflect.ValueOf(i)
 	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
 		err.Add(errors.New("Interface must be a pointer to struct."), "", 0)
+	} else if _, e := defaultStructMap.getOrLoad(val.Elem().Type()); e != nil {
+		// Struct spec could not be loaded.
+		err.Add(e, "", 0)
 	} else {
 		rv := val.Elem()
+		if opts.IgnoreCase {
+			data = foldKeys(rv.Type(), data)
+		}
 		for path, values := range data {
+			if strings.Count(path, ".") >= MaxDepth {
+				err.Add(fmt.Errorf("Key has more than %d parts.", MaxDepth),
+					path, 0)
+				continue
+			}
 			parts := strings.Split(path, ".")
-			loadValue(rv, values, parts, path, err)
+			if !loadValue(rv, values, parts, path, err) && opts.Strict {
+				err.Add(errors.New("Unknown key."), path, 0)
+			}
 		}
+		loadMissing(rv, data, "", err)
 	}
 	if err.Error() == "" {
 		return nil
@@ -192,6 +264,81 @@ func loadAndValidate(i interface{}, data map[string][]string,
 // Internals
 // ----------------------------------------------------------------------------
 
+// foldKeys returns a copy of data with the keys rewritten to the field names
+// they match ignoring case, for the struct type t.
+//
+// Keys that match exactly take precedence over the keys rewritten to them.
+// The values of other keys rewritten to the same name are merged in the
+// sorted order of the keys, so that the result doesn't depend on the map
+// iteration order.
+func foldKeys(t reflect.Type, data map[string][]string) map[string][]string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	folded := make(map[string][]string, len(data))
+	for _, key := range keys {
+		values := data[key]
+		if strings.Count(key, ".") >= MaxDepth {
+			// Reported by the caller.
+			folded[key] = values
+			continue
+		}
+		parts := strings.Split(key, ".")
+		foldPath(t, parts)
+		name := strings.Join(parts, ".")
+		if _, ok := data[name]; ok && name != key {
+			continue
+		}
+		if merged, ok := folded[name]; ok {
+			values = append(append([]string(nil), merged...), values...)
+		}
+		folded[name] = values
+	}
+	return folded
+}
+
+// foldPath replaces the parts of a path in a struct type t by the field
+// names they match ignoring case. It stops at the first part that doesn't
+// match a field.
+func foldPath(t reflect.Type, parts []string) {
+	for i := 0; i < len(parts); i++ {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return
+		}
+		spec, err := defaultStructMap.getOrLoad(t)
+		if err != nil {
+			return
+		}
+		fieldSpec, ok := spec.fields[parts[i]]
+		if !ok {
+			name, ok := spec.foldedNames[strings.ToLower(parts[i])]
+			if !ok {
+				return
+			}
+			fieldSpec = spec.fields[name]
+			parts[i] = name
+		}
+		field, _ := t.FieldByName(fieldSpec.realName)
+		t = field.Type
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Slice:
+			t = t.Elem()
+		case reflect.Map:
+			// Skip the map index.
+			i++
+			t = t.Elem()
+		}
+	}
+}
+
 // loadValue sets the value for a path in a struct.
 //
 // - rv is the current struct being walked.
@@ -203,19 +350,21 @@ func loadAndValidate(i interface{}, data map[string][]string,
 // - key is the unmodified data key.
 //
 // - se is the SchemaError instance to save errors.
+//
+// It returns false if the path doesn't map to a struct field.
 func loadValue(rv reflect.Value, values, parts []string, key string,
-	se *SchemaError) {
+	se *SchemaError) bool {
 	spec, err := defaultStructMap.getOrLoad(rv.Type())
 	if err != nil {
 		// Struct spec could not be loaded.
 		se.Add(err, "", 0)
-		return
+		return true
 	}
 
 	fieldSpec, ok := spec.fields[parts[0]]
 	if !ok {
 		// Field doesn't exist.
-		return
+		return false
 	}
 
 	parts = parts[1:]
@@ -223,7 +372,7 @@ func loadValue(rv reflect.Value, values, parts []string, key string,
 	kind := field.Kind()
 	if (kind == reflect.Struct || (kind == reflect.Slice && len(parts) > 0) || kind == reflect.Map) == (len(parts) == 0) {
 		// Last part can't be a struct or map. Others must be a struct or map.
-		return
+		return false
 	}
 
 	var idx string
@@ -233,10 +382,16 @@ func loadValue(rv reflect.Value, values, parts []string, key string,
 		parts = parts[1:]
 		if len(parts) > 0 {
 			// Last part must be the map index.
-			return
+			return false
 		}
 	}
 
+	if kind == reflect.Slice && len(values) > MaxSliceLen {
+		se.Add(fmt.Errorf("Too many values: %d, the maximum is %d.",
+			len(values), MaxSliceLen), key, 0)
+		return true
+	}
+
 	if len(parts) > 0 {
 		if kind == reflect.Slice {
 			if field.IsNil() {
@@ -245,13 +400,14 @@ func loadValue(rv reflect.Value, values, parts []string, key string,
 			}
 			for i := 0; i < len(values); i++ {
 				sv := field.Index(i)
-				loadValue(sv, values[i:i+1], parts, key, se)
+				if !loadValue(sv, values[i:i+1], parts, key, se) {
+					return false
+				}
 			}
-		} else {
-			// A struct. Move to next part.
-			loadValue(field, values, parts, key, se)
+			return true
 		}
-		return
+		// A struct. Move to next part.
+		return loadValue(field, values, parts, key, se)
 	}
 
 	// Last part: set the value.
@@ -307,7 +463,54 @@ func loadValue(rv reflect.Value, values, parts []string, key string,
 		}
 		field.Set(slice)
 	}
-	return
+	return true
+}
+
+// loadMissing handles the fields whose key is absent from data, recursing
+// into nested structs: it sets those that have a default value and reports
+// an error for the required ones.
+//
+// - prefix is the dotted path of rv in data keys.
+//
+// - se is the SchemaError instance to save errors.
+func loadMissing(rv reflect.Value, data map[string][]string, prefix string,
+	se *SchemaError) {
+	spec, err := defaultStructMap.getOrLoad(rv.Type())
+	if err != nil {
+		return
+	}
+	for _, fieldSpec := range spec.fields {
+		key := prefix + fieldSpec.name
+		if fieldSpec.defaultValue == "" {
+			if fieldSpec.required && isEmpty(data[key]) {
+				se.Add(errors.New("Missing required value."), key, 0)
+			} else if field := rv.FieldByName(fieldSpec.realName); field.Kind() == reflect.Struct {
+				loadMissing(field, data, key+".", se)
+			}
+			continue
+		}
+		if _, ok := data[key]; ok {
+			continue
+		}
+		field := setIndirect(rv.FieldByName(fieldSpec.realName))
+		// The default was validated when the struct spec was loaded.
+		value, _ := coerce(field.Kind(), fieldSpec.defaultValue)
+		if conv := getTypeConverter(field.Type()); conv != nil {
+			value = conv(value)
+		}
+		field.Set(value)
+	}
+}
+
+// isEmpty returns true if values has no value other than empty strings, as
+// submitted by an empty text input.
+func isEmpty(values []string) bool {
+	for _, v := range values {
+		if v != "" {
+			return false
+		}
+	}
+	return true
 }
 
 // coerce coerces basic types from a string to a reflect.Value of a given kind.
@@ -315,7 +518,7 @@ func coerce(kind reflect.Kind, value string) (rv reflect.Value, err error) {
 	switch kind {
 	case reflect.Bool:
 		var v bool
-		v, err = strconv.Atob(value)
+		v, err = parseBool(value)
 		rv = reflect.ValueOf(v)
 	case reflect.Float32:
 		var v float32
@@ -373,6 +576,20 @@ func coerce(kind reflect.Kind, value string) (rv reflect.Value, err error) {
 	return
 }
 
+// parseBool converts a form value to a bool.
+//
+// Besides "1", "t" and "true" and their false counterparts, it accepts "on"
+// and "off", which is what HTML checkboxes submit. Case is ignored.
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "1", "t", "true", "on":
+		return true, nil
+	case "0", "f", "false", "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("Invalid boolean value: %q.", value)
+}
+
 // ----------------------------------------------------------------------------
 // structMap
 // ----------------------------------------------------------------------------
@@ -422,6 +639,7 @@ func (m *structMap) getOrLoad(t reflect.Type) (spec *structSpec,
 		for _, v := range loaded {
 			delete(m.specs, v)
 		}
+		m.mutex.Unlock()
 		return
 	}
 	m.mutex.Unlock()
@@ -448,7 +666,10 @@ func (m *structMap) load(t reflect.Type, loaded *[]string) (spec *structSpec,
 	}
 
 	structId := getTypeId(t)
-	spec = &structSpec{fields: make(map[string]*structFieldSpec)}
+	spec = &structSpec{
+		fields:      make(map[string]*structFieldSpec),
+		foldedNames: make(map[string]string),
+	}
 	m.specs[structId] = spec
 	*loaded = append(*loaded, structId)
 
@@ -495,22 +716,65 @@ func (m *structMap) load(t reflect.Type, loaded *[]string) (spec *structSpec,
 			}
 		}
 		uniqueNames[i] = name
+		if _, ok := spec.foldedNames[strings.ToLower(name)]; !ok {
+			spec.foldedNames[strings.ToLower(name)] = name
+		}
 
 		// Set tags.
 		tags := make([]string, 0)
+		required := false
 		tagStrings := field.Tag.Get("schema-tags")
 		for _, tag := range strings.Split(tagStrings, " ") {
 			tag := strings.TrimSpace(tag)
 			if tag != "" {
 				tags = append(tags, tag)
 			}
+			if tag == "required" {
+				required = true
+			}
+		}
+
+		// Required fields must be filled from a single key: basic types or
+		// slices of basic types.
+		if required {
+			ft := field.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Slice {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct || !isSupportedBasicType(ft) {
+				return nil, fmt.Errorf("The required tag is only supported "+
+					"for basic types and slices of them, field %q is a %v.",
+					field.Name, field.Type)
+			}
+		}
+
+		// Set the default value, only supported for basic types.
+		defaultValue := field.Tag.Get("schema-default")
+		if defaultValue != "" {
+			ft := field.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct || !isSupportedBasicType(ft) {
+				return nil, fmt.Errorf("Default values are only supported "+
+					"for basic types, field %q is a %v.", field.Name, ft)
+			}
+			if _, err = coerce(ft.Kind(), defaultValue); err != nil {
+				return nil, fmt.Errorf("Invalid default value for field "+
+					"%q: %v", field.Name, err)
+			}
 		}
 
 		// Finally, set the field.
 		spec.fields[name] = &structFieldSpec{
-			name:     name,
-			realName: field.Name,
-			tags:     tags,
+			name:         name,
+			realName:     field.Name,
+			tags:         tags,
+			required:     required,
+			defaultValue: defaultValue,
 		}
 	}
 	return
@@ -527,6 +791,9 @@ func (m *structMap) load(t reflect.Type, loaded *[]string) (spec *structSpec,
 // filters and conversions.
 type structSpec struct {
 	fields map[string]*structFieldSpec
+	// Field names by lowercase name, for case-insensitive matching. If
+	// several names only differ by case, the first field's is used.
+	foldedNames map[string]string
 }
 
 // ----------------------------------------------------------------------------
@@ -541,6 +808,10 @@ type structFieldSpec struct {
 	realName string
 	// Tags, used to identify filters and validators.
 	tags []string
+	// True if the field has the "required" tag.
+	required bool
+	// Value set when the field key is absent, or an empty string.
+	defaultValue string
 }
 
 // ----------------------------------------------------------------------------
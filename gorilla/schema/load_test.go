@@ -5,7 +5,9 @@
 package schema
 
 import (
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -432,6 +434,33 @@ func TestCompositeType(t *testing.T) {
 	}
 }
 
+func TestConcurrentLoad(t *testing.T) {
+	AddTypeConverter(reflect.TypeOf(stringType("")), convStringType)
+	v := map[string][]string{
+		"F01":     {"foo"},
+		"F02":     {"foo", "bar"},
+		"F03.foo": {"bar"},
+	}
+	done := make(chan error)
+	for i := 0; i < 10; i++ {
+		go func() {
+			// Adding converters while loading must be safe too.
+			AddTypeConverter(reflect.TypeOf(stringType("")), convStringType)
+			target := new(TestStruct4)
+			err := Load(target, v)
+			if err == nil && (target.F01 != "foo" || len(target.F02) != 2 || target.F03["foo"] != "bar") {
+				err = fmt.Errorf("Unexpected values: %+v", target)
+			}
+			done <- err
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
 // ----------------------------------------------------------------------------
 // Example from the docs.
 
@@ -505,3 +534,244 @@ func TestMultiStructField(t *testing.T) {
 		}
 	*/
 }
+
+// ----------------------------------------------------------------------------
+
+type TestStruct5 struct {
+	F01 bool
+	F02 bool
+}
+
+func TestBoolValues(t *testing.T) {
+	tests := map[string]bool{
+		"1": true, "t": true, "T": true, "true": true, "TRUE": true, "on": true, "On": true,
+		"0": false, "f": false, "F": false, "false": false, "False": false, "off": false, "OFF": false,
+	}
+	for value, expected := range tests {
+		s := &TestStruct5{F01: !expected}
+		if err := Load(s, map[string][]string{"F01": {value}}); err != nil {
+			t.Errorf("%q: unexpected error: %v", value, err)
+		} else if s.F01 != expected {
+			t.Errorf("%q: expected %v, got %v", value, expected, s.F01)
+		}
+	}
+
+	// An unchecked checkbox is absent from the form.
+	s := &TestStruct5{}
+	if err := Load(s, map[string][]string{"F01": {"on"}}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !s.F01 || s.F02 {
+		t.Errorf("Expected F01 true and F02 false, got %v and %v", s.F01, s.F02)
+	}
+
+	err := Load(&TestStruct5{}, map[string][]string{"F01": {"yes"}})
+	if schemaErr, ok := err.(*SchemaError); !ok || schemaErr.Err("F01") == nil {
+		t.Errorf("Expected an error for 'F01', got %v", err)
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+type TestStruct6 struct {
+	Page  int    `schema-default:"1"`
+	Sort  string `schema-default:"name"`
+	Limit *uint  `schema-default:"20"`
+	Query string
+}
+
+type TestStruct7 struct {
+	Page int `schema-default:"first"`
+}
+
+type TestStruct8 struct {
+	Pages []int `schema-default:"1"`
+}
+
+func TestDefaultValues(t *testing.T) {
+	s := &TestStruct6{}
+	if err := Load(s, map[string][]string{"Sort": {"date"}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s.Page != 1 || s.Sort != "date" || s.Limit == nil || *s.Limit != 20 || s.Query != "" {
+		t.Errorf("Expected defaults for absent fields only, got %+v", s)
+	}
+
+	// Defaults also apply to an empty source map.
+	s = &TestStruct6{}
+	if err := Load(s, map[string][]string{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s.Page != 1 || s.Sort != "name" {
+		t.Errorf("Expected defaults, got %+v", s)
+	}
+
+	for _, v := range []interface{}{&TestStruct7{}, &TestStruct8{}} {
+		if err := Load(v, map[string][]string{}); err == nil {
+			t.Errorf("%T: expected an error for a bad default", v)
+		}
+	}
+}
+
+type TestStruct9 struct {
+	Email string   `schema-tags:"required"`
+	Tags  []string `schema-tags:"required"`
+	Page  int      `schema-default:"1"`
+	Inner TestStruct10
+}
+
+type TestStruct10 struct {
+	Name string `schema-tags:"required"`
+}
+
+type TestStruct11 struct {
+	Scores map[string]int `schema-tags:"required"`
+}
+
+func TestRequiredValues(t *testing.T) {
+	s := &TestStruct9{}
+	err := Load(s, map[string][]string{
+		"Email":      {""},
+		"Page":       {"3"},
+		"Inner.Name": {"x"},
+	})
+	se, ok := err.(*SchemaError)
+	if !ok {
+		t.Fatalf("Expected a SchemaError, got %v", err)
+	}
+	if len(se.Err("Email")) != 1 || len(se.Err("Tags")) != 1 || len(se.Errors()) != 2 {
+		t.Errorf("Expected errors for Email and Tags, got %v", se.Errors())
+	}
+	if s.Page != 3 || s.Inner.Name != "x" {
+		t.Errorf("Expected the present values to be loaded, got %+v", s)
+	}
+
+	s = &TestStruct9{}
+	err = Load(s, map[string][]string{
+		"Email":      {"a@example.com"},
+		"Tags":       {"go"},
+		"Inner.Name": {"x"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s.Page != 1 {
+		t.Errorf("Expected the default page, got %d", s.Page)
+	}
+
+	// A missing required field in a nested struct.
+	err = Load(&TestStruct9{}, map[string][]string{"Email": {"e"}, "Tags": {"t"}})
+	if se, ok := err.(*SchemaError); !ok || len(se.Err("Inner.Name")) != 1 {
+		t.Errorf("Expected an error for Inner.Name, got %v", err)
+	}
+
+	if err := Load(&TestStruct11{}, map[string][]string{}); err == nil {
+		t.Errorf("Expected an error for a required map")
+	}
+}
+
+func TestUnknownKeys(t *testing.T) {
+	data := map[string][]string{
+		"F01":        {"true"},
+		"csrf_token": {"x"},
+		"F02.Inner":  {"1"},
+	}
+	s := &TestStruct5{}
+	if err := Load(s, data); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !s.F01 {
+		t.Errorf("Expected F01 to be loaded.")
+	}
+
+	s = &TestStruct5{}
+	err := LoadStrict(s, data)
+	se, ok := err.(*SchemaError)
+	if !ok {
+		t.Fatalf("Expected a SchemaError, got %v", err)
+	}
+	if len(se.Err("csrf_token")) != 1 || len(se.Err("F02.Inner")) != 1 || len(se.Errors()) != 2 {
+		t.Errorf("Expected errors for the unknown keys, got %v", se.Errors())
+	}
+	if !s.F01 {
+		t.Errorf("Expected F01 to be loaded.")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	values := make([]string, MaxSliceLen+1)
+	for i := range values {
+		values[i] = "1"
+	}
+	s := &TestStruct1{}
+	err := Load(s, map[string][]string{"F18": values, "F04": {"1"}})
+	if se, ok := err.(*SchemaError); !ok || len(se.Err("F18")) != 1 {
+		t.Errorf("Expected an error for too many values, got %v", err)
+	}
+	if s.F18 != nil || s.F04 != 1 {
+		t.Errorf("Expected only F04 to be loaded, got %v and %v", s.F18, s.F04)
+	}
+	if err := Load(s, map[string][]string{"F18": values[:MaxSliceLen]}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	key := "F01" + strings.Repeat(".F01", MaxDepth)
+	err = Load(&TestStruct1{}, map[string][]string{key: {"1"}})
+	if se, ok := err.(*SchemaError); !ok || len(se.Err(key)) != 1 {
+		t.Errorf("Expected an error for a deep key, got %v", err)
+	}
+}
+
+type TestStruct14 struct {
+	Field1 string
+	FIELD2 string
+	Field2 string
+	Inner  TestStruct13 `schema-name:"Nested"`
+	Tags   []string
+}
+
+func TestIgnoreCase(t *testing.T) {
+	data := map[string][]string{
+		"field1":       {"a"},
+		"field2":       {"b"},
+		"nested.LABEL": {"c"},
+	}
+	s := &TestStruct14{}
+	if err := LoadWithOptions(s, data, LoadOptions{IgnoreCase: true}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// field2 matches FIELD2, the first of the fields that only differ by
+	// case.
+	if s.Field1 != "a" || s.FIELD2 != "b" || s.Field2 != "" || s.Inner.Label != "c" {
+		t.Errorf("Unexpected values: %+v", s)
+	}
+
+	// A key that matches exactly takes precedence.
+	s = &TestStruct14{}
+	data = map[string][]string{"Field2": {"exact"}, "field1": {"x"}, "FIELD1": {"y"}, "Field1": {"z"}}
+	if err := LoadWithOptions(s, data, LoadOptions{IgnoreCase: true}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s.Field2 != "exact" || s.FIELD2 != "" || s.Field1 != "z" {
+		t.Errorf("Unexpected values: %+v", s)
+	}
+
+	// Keys that fold to the same field, none of them exactly, are merged in
+	// sorted key order.
+	for i := 0; i < 10; i++ {
+		s = &TestStruct14{}
+		data = map[string][]string{"tags": {"c"}, "TAGS": {"a"}, "tAgS": {"b"}}
+		if err := LoadWithOptions(s, data, LoadOptions{IgnoreCase: true}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(s.Tags) != 3 || s.Tags[0] != "a" || s.Tags[1] != "b" || s.Tags[2] != "c" {
+			t.Fatalf("Expected tags [a b c], got %v", s.Tags)
+		}
+	}
+
+	// Without the option, keys must match exactly.
+	s = &TestStruct14{}
+	if err := Load(s, map[string][]string{"field1": {"a"}}); err != nil || s.Field1 != "" {
+		t.Errorf("Expected field1 to be ignored, got %+v, %v", s, err)
+	}
+}
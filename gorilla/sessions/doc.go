@@ -147,6 +147,15 @@ After this, cookies will last for a week only. The Config() function
 accepts an optional argument besides the request: the session key. If not
 defined, the configuration for the default session key is returned.
 
+To destroy a session, e.g., on logout, call sessions.Delete(). It clears the
+session values and sets an expired cookie in the response:
+
+	sessions.Delete(r, w)
+
+Like Session(), it accepts optional session and store keys. Stores that keep
+session data elsewhere than the cookie can implement SessionDeleter to remove
+that data as well. A deleted session is not saved again by sessions.Save().
+
 Bonus: flash messages. What are they? It basically means "session values that
 last until read". The term was popularized by Ruby On Rails a few years back.
 When we request a flash message, it is removed from the session. We have two
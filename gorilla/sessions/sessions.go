@@ -118,6 +118,14 @@ func Save(r *http.Request, w http.ResponseWriter) []error {
 	return DefaultSessionFactory.Save(r, w)
 }
 
+// Delete deletes a session, e.g., on logout.
+//
+// The variadic arguments are optional: (sessionKey, storeKey).
+// If not defined or empty the default values are used.
+func Delete(r *http.Request, w http.ResponseWriter, vars ...string) error {
+	return DefaultSessionFactory.Delete(r, w, vars...)
+}
+
 // Store returns a session store for the given key.
 func Store(key string) (SessionStore, error) {
 	return DefaultSessionFactory.Store(key)
@@ -200,6 +208,11 @@ func (f *SessionFactory) SetStore(key string, store SessionStore) {
 // Multiple pairs are accepted to allow key rotation, but the common case is
 // to set a single authentication key and optionally an encryption key.
 //
+// Values are always encoded using the first pair. When decoding, the pairs
+// are tried in the order they were given, and the first one that succeeds is
+// used. To rotate keys, prepend the new pair and keep the previous ones after
+// it until the sessions encoded with them have expired.
+//
 // The encryption key, if set, must be either 16, 24, or 32 bytes to select
 // AES-128, AES-192, or AES-256 modes.
 func (f *SessionFactory) SetStoreKeys(key string,
@@ -299,6 +312,20 @@ func (f *SessionFactory) Save(r *http.Request,
 	return getRequestSessions(f, r).Save(w)
 }
 
+// Delete deletes a session, e.g., on logout.
+//
+// The session data is cleared and an expired cookie is set in the response.
+// If the store implements SessionDeleter, it is also asked to remove the
+// data it keeps for the session. A deleted session is not saved again by
+// Save().
+//
+// The variadic arguments are optional: (sessionKey, storeKey).
+// If not defined or empty the default values are used.
+func (f *SessionFactory) Delete(r *http.Request, w http.ResponseWriter,
+	vars ...string) error {
+	return getRequestSessions(f, r).Delete(w, vars...)
+}
+
 // DefaultConfig returns the default session configuration used by the factory.
 func (f *SessionFactory) DefaultConfig() *SessionConfig {
 	if f.defaultConfig == nil {
@@ -366,6 +393,8 @@ type requestSessions struct {
 	factory  *SessionFactory
 	request  *http.Request
 	sessions map[string]SessionInfo
+	// Keys of sessions deleted during the request; they are not saved.
+	deleted map[string]bool
 }
 
 // Session returns a session given its key and store.
@@ -413,12 +442,67 @@ func (s *requestSessions) Config(key ...string) (*SessionConfig, error) {
 	return nil, ErrNoSession
 }
 
+// Delete deletes a session given its key and store.
+//
+// The variadic arguments are optional: (sessionKey, storeKey).
+// If not defined or empty the default values are used.
+func (s *requestSessions) Delete(w http.ResponseWriter, vars ...string) error {
+	sessionKey, storeKey := sessionKeys(vars...)
+	store, err := s.factory.Store(storeKey)
+	if err != nil {
+		return err
+	}
+	if s.sessions == nil {
+		s.sessions = make(map[string]SessionInfo)
+	}
+	if s.deleted == nil {
+		s.deleted = make(map[string]bool)
+	}
+	info, ok := s.sessions[sessionKey]
+	if ok {
+		if store != info.Store {
+			// Store should match.
+			return ErrStoreMismatch
+		}
+		// Clear the data in case the session is still referenced.
+		for k, _ := range info.Data {
+			delete(info.Data, k)
+		}
+	} else {
+		info = SessionInfo{
+			Data:   SessionData{},
+			Store:  store,
+			Config: s.factory.defaultConfigValue(),
+		}
+	}
+	if deleter, ok := store.(SessionDeleter); ok {
+		if err = deleter.Delete(s.request, sessionKey); err != nil {
+			return err
+		}
+	}
+	s.sessions[sessionKey] = info
+	s.deleted[sessionKey] = true
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionKey,
+		Value:    "",
+		Path:     info.Config.Path,
+		Domain:   info.Config.Domain,
+		MaxAge:   -1,
+		Secure:   info.Config.Secure,
+		HttpOnly: info.Config.HttpOnly,
+	})
+	return nil
+}
+
 // Save saves all sessions accessed during the request.
 func (s *requestSessions) Save(w http.ResponseWriter) []error {
 	var err error
 	var ok bool
 	var errors []error
 	for key, info := range s.sessions {
+		if s.deleted[key] {
+			continue
+		}
 		if ok, err = info.Store.Save(s.request, w, key, &info); !ok {
 			if errors == nil {
 				errors = []error{err}
@@ -455,6 +539,12 @@ type SessionStore interface {
 	SetEncoders(encoders ...SessionEncoder)
 }
 
+// SessionDeleter is implemented by session stores that keep session data
+// outside of the cookie, so that it can be removed when a session is deleted.
+type SessionDeleter interface {
+	Delete(r *http.Request, key string) error
+}
+
 // ----------------------------------------------------------------------------
 // CookieSessionStore
 // ----------------------------------------------------------------------------
@@ -10,6 +10,7 @@ import (
 	"crypto/hmac"
 	"fmt"
 	"http"
+	"strings"
 	"testing"
 	"time"
 )
@@ -517,4 +518,133 @@ func TestKeyRotation(t *testing.T) {
 	}
 }
 
+func TestKeyRotationPrepend(t *testing.T) {
+	var req *http.Request
+	var rsp *ResponseRecorder
+	var hdr http.Header
+	var err error
+	var err2 bool
+	var session SessionData
+	var cookies []string
+
+	oldKeys := [][]byte{[]byte("my-old-secret-key"), []byte("1234567890123456")}
+	newKeys := [][]byte{[]byte("my-new-secret-key"), []byte("6543210987654321")}
+
+	// Round 1 ----------------------------------------------------------------
+	// Create a cookie using the old keys.
+	DefaultSessionFactory.SetStoreKeys("cookie", oldKeys...)
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp = NewRecorder()
+	if session, err = Session(req); err == nil {
+		session["a"] = "1"
+		Save(req, rsp)
+	} else {
+		t.Error(err)
+	}
+	hdr = rsp.Header()
+	cookies, err2 = hdr["Set-Cookie"]
+	if !err2 || len(cookies) != 1 {
+		t.Fatalf("Expected Set-Cookie key. Header: %v", hdr)
+	}
+
+	// Round 2 ----------------------------------------------------------------
+	// Prepend the new keys: the old cookie still decodes, and is saved again
+	// using the new keys.
+	DefaultSessionFactory.SetStoreKeys("cookie",
+		newKeys[0], newKeys[1], oldKeys[0], oldKeys[1])
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", cookies[0])
+	rsp = NewRecorder()
+	if session, err = Session(req); err != nil || session["a"] != "1" {
+		t.Errorf("Expected single value session; Got %v", session)
+	}
+	Save(req, rsp)
+	hdr = rsp.Header()
+	cookies, err2 = hdr["Set-Cookie"]
+	if !err2 || len(cookies) != 1 {
+		t.Fatalf("Expected Set-Cookie key. Header: %v", hdr)
+	}
+
+	// Round 3 ----------------------------------------------------------------
+	// The new cookie only decodes with the new keys.
+	DefaultSessionFactory.SetStoreKeys("cookie", oldKeys...)
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", cookies[0])
+	if session, err = Session(req); err != nil || len(session) != 0 {
+		t.Errorf("Expected empty session; Got %v", session)
+	}
+
+	DefaultSessionFactory.SetStoreKeys("cookie", newKeys...)
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", cookies[0])
+	if session, err = Session(req); err != nil || session["a"] != "1" {
+		t.Errorf("Expected single value session; Got %v", session)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	var req *http.Request
+	var rsp *ResponseRecorder
+	var hdr http.Header
+	var err error
+	var err2 bool
+	var session SessionData
+	var cookies []string
+
+	DefaultSessionFactory.SetStoreKeys("cookie",
+		[]byte("my-secret-key"),
+		[]byte("1234567890123456"))
+
+	// Round 1 ----------------------------------------------------------------
+	// Set some values.
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	rsp = NewRecorder()
+	if session, err = Session(req); err == nil {
+		session["a"] = "1"
+		Save(req, rsp)
+	} else {
+		t.Error(err)
+	}
+	hdr = rsp.Header()
+	cookies, err2 = hdr["Set-Cookie"]
+	if !err2 || len(cookies) != 1 {
+		t.Fatalf("Expected Set-Cookie key. Header: %v", hdr)
+	}
+
+	// Round 2 ----------------------------------------------------------------
+	// Delete the session.
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", cookies[0])
+	rsp = NewRecorder()
+	if session, err = Session(req); err != nil || session["a"] != "1" {
+		t.Errorf("Expected single value session; Got %v", session)
+	}
+	if err = Delete(req, rsp); err != nil {
+		t.Error(err)
+	}
+	if len(session) != 0 {
+		t.Errorf("Expected cleared session; Got %v", session)
+	}
+	if session, err = Session(req); err != nil || len(session) != 0 {
+		t.Errorf("Expected empty session; Got %v", session)
+	}
+	Save(req, rsp)
+	hdr = rsp.Header()
+	cookies, err2 = hdr["Set-Cookie"]
+	if !err2 || len(cookies) != 1 {
+		t.Fatalf("Expected a single Set-Cookie key. Header: %v", hdr)
+	}
+	if !strings.Contains(cookies[0], "Max-Age=0") {
+		t.Errorf("Expected an expiring cookie; Got %q", cookies[0])
+	}
+
+	// Round 3 ----------------------------------------------------------------
+	// The next request sees no data.
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	req.Header.Add("Cookie", cookies[0])
+	if session, err = Session(req); err != nil || len(session) != 0 {
+		t.Errorf("Expected empty session; Got %v", session)
+	}
+}
+
 // TODO test Config()
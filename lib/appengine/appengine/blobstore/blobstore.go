@@ -8,6 +8,7 @@ package blobstore
 
 import (
 	"bufio"
+	"compress/gzip"
 	"errors"
 	"fmt"
 	"http"
@@ -56,10 +57,16 @@ func Stat(c appengine.Context, blobKey appengine.BlobKey) (*BlobInfo, error) {
 	if err := datastore.Get(c, dskey, m); err != nil {
 		return nil, err
 	}
-	contentType, ok0 := m["content_type"].(string)
-	filename, ok1 := m["filename"].(string)
-	size, ok2 := m["size"].(int64)
-	creation, ok3 := m["creation"].(datastore.Time)
+	return blobInfoFromMap(blobKey, m)
+}
+
+// blobInfoFromMap converts a __BlobInfo__ entity loaded into a Map to a
+// BlobInfo.
+func blobInfoFromMap(blobKey appengine.BlobKey, m datastore.Map) (*BlobInfo, error) {
+	contentType, ok0 := m.String("content_type")
+	filename, ok1 := m.String("filename")
+	size, ok2 := m.Int64("size")
+	creation, ok3 := m.Time("creation")
 	if !ok0 || !ok1 || !ok2 || !ok3 {
 		return nil, errors.New("blobstore: invalid blob info")
 	}
@@ -73,6 +80,48 @@ func Stat(c appengine.Context, blobKey appengine.BlobKey) (*BlobInfo, error) {
 	return bi, nil
 }
 
+// ListOptions are the options to list blobs.
+type ListOptions struct {
+	// Limit is the maximum number of blobs to return.
+	// A zero value means unlimited.
+	Limit int
+	// Start is the cursor returned by a previous call to List, to fetch the
+	// next page. A zero Cursor starts at the oldest blob.
+	Start datastore.Cursor
+}
+
+// List returns the BlobInfo of the app's blobs, ordered by creation time, and
+// a cursor positioned after the last one. Passing that cursor in the Start
+// field of opts fetches the next page. The opts parameter may be nil.
+func List(c appengine.Context, opts *ListOptions) ([]*BlobInfo, datastore.Cursor, error) {
+	q := datastore.NewQuery(blobInfoKind).Order("creation").KeepCursor()
+	if opts != nil {
+		q = q.Limit(opts.Limit).Start(opts.Start)
+	}
+	var infos []*BlobInfo
+	t := q.Run(c)
+	for {
+		m := make(datastore.Map)
+		k, err := t.Next(m)
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			return nil, datastore.Cursor{}, err
+		}
+		bi, err := blobInfoFromMap(appengine.BlobKey(k.StringID()), m)
+		if err != nil {
+			return nil, datastore.Cursor{}, err
+		}
+		infos = append(infos, bi)
+	}
+	cursor, err := t.Cursor()
+	if err != nil {
+		return nil, datastore.Cursor{}, err
+	}
+	return infos, cursor, nil
+}
+
 // Send sets the headers on response to instruct App Engine to send a blob as
 // the response body. This is more efficient than reading and writing it out
 // manually and isn't subject to normal response size limits.
@@ -103,6 +152,9 @@ func UploadURL(c appengine.Context, successPath string, opts *UploadURLOptions)
 		if opts.MaxUploadBytesPerBlob != 0 {
 			req.MaxUploadSizePerBlobBytes = proto.Int64(opts.MaxUploadBytesPerBlob)
 		}
+		if opts.GSBucketName != "" {
+			req.GsBucketName = proto.String(opts.GSBucketName)
+		}
 	}
 	res := &pb.CreateUploadURLResponse{}
 	if err := c.Call("blobstore", "CreateUploadURL", req, res, nil); err != nil {
@@ -115,6 +167,10 @@ func UploadURL(c appengine.Context, successPath string, opts *UploadURLOptions)
 type UploadURLOptions struct {
 	MaxUploadBytes        int64 // optional
 	MaxUploadBytesPerBlob int64 // optional
+
+	// GSBucketName, if set, is the Google Cloud Storage bucket that
+	// uploaded files are stored in, instead of the Blobstore.
+	GSBucketName string // optional
 }
 
 // Delete deletes a blob.
@@ -138,6 +194,61 @@ func DeleteMulti(c appengine.Context, blobKey []appengine.BlobKey) error {
 	return nil
 }
 
+// deleteBatchSize is the number of blobs DeleteByPrefix deletes per call.
+const deleteBatchSize = 500
+
+// DeleteByPrefix deletes the blobs whose filename starts with prefix and
+// returns how many were deleted. The prefix must be non-empty: to delete all
+// blobs, use List and DeleteMulti.
+//
+// The blobs are deleted in batches as they are found, so on error some of
+// them may have been deleted already; the count includes them.
+func DeleteByPrefix(c appengine.Context, prefix string) (int, error) {
+	if prefix == "" {
+		return 0, errors.New("blobstore: empty filename prefix")
+	}
+	// Blobs are listed in filename order starting at the prefix, so the
+	// matching ones come first.
+	q := datastore.NewQuery(blobInfoKind).Filter("filename >=", prefix).Order("filename")
+	n := 0
+	var batch []appengine.BlobKey
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := DeleteMulti(c, batch); err != nil {
+			return err
+		}
+		n += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	t := q.Run(c)
+	for {
+		m := make(datastore.Map)
+		k, err := t.Next(m)
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+		if filename, _ := m["filename"].(string); !strings.HasPrefix(filename, prefix) {
+			break
+		}
+		batch = append(batch, appengine.BlobKey(k.StringID()))
+		if len(batch) == deleteBatchSize {
+			if err := flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
 func errorf(format string, args ...interface{}) error {
 	return fmt.Errorf("blobstore: "+format, args...)
 }
@@ -147,14 +258,34 @@ func errorf(format string, args ...interface{}) error {
 // ParseUpload returns a map of the blobs received (keyed by HTML form
 // element name) and other non-blob POST parameters.
 func ParseUpload(req *http.Request) (blobs map[string][]*BlobInfo, other map[string][]string, err error) {
+	other = make(map[string][]string)
+	blobs, err = ParseUploadStream(req, func(formKey string, part *multipart.Part) error {
+		slurp, serr := ioutil.ReadAll(part)
+		if serr != nil {
+			return errorf("error reading %q MIME part", formKey)
+		}
+		other[formKey] = append(other[formKey], string(slurp))
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return blobs, other, nil
+}
+
+// ParseUploadStream is like ParseUpload, but instead of reading the non-blob
+// POST parameters into memory it calls fn with each of their MIME parts, in
+// order, so that large values can be streamed. The part is only valid until
+// fn returns. If fn returns an error, parsing stops and that error is
+// returned.
+func ParseUploadStream(req *http.Request, fn func(formKey string, part *multipart.Part) error) (blobs map[string][]*BlobInfo, err error) {
 	_, params := mime.ParseMediaType(req.Header.Get("Content-Type"))
 	boundary := params["boundary"]
 	if boundary == "" {
-		return nil, nil, errorf("did not find MIME multipart boundary")
+		return nil, errorf("did not find MIME multipart boundary")
 	}
 
 	blobs = make(map[string][]*BlobInfo)
-	other = make(map[string][]string)
 
 	mreader := multipart.NewReader(io.MultiReader(req.Body, strings.NewReader("\r\n\r\n")), boundary)
 	for {
@@ -163,7 +294,7 @@ func ParseUpload(req *http.Request) (blobs map[string][]*BlobInfo, other map[str
 			break
 		}
 		if perr != nil {
-			return nil, nil, errorf("error reading next mime part with boundary %q (len=%d): %v",
+			return nil, errorf("error reading next mime part with boundary %q (len=%d): %v",
 				boundary, len(boundary), perr)
 		}
 
@@ -176,47 +307,52 @@ func ParseUpload(req *http.Request) (blobs map[string][]*BlobInfo, other map[str
 		bi.BlobKey = appengine.BlobKey(params["blob-key"])
 		if ctype != "message/external-body" || bi.BlobKey == "" {
 			if formKey != "" {
-				slurp, serr := ioutil.ReadAll(part)
-				if serr != nil {
-					return nil, nil, errorf("error reading %q MIME part", formKey)
+				if err = fn(formKey, part); err != nil {
+					return nil, err
 				}
-				other[formKey] = append(other[formKey], string(slurp))
 			}
 			continue
 		}
-
-		// App Engine sends a MIME header as the body of each MIME part.
-		tp := textproto.NewReader(bufio.NewReader(part))
-		header, mimeerr := tp.ReadMIMEHeader()
-		if mimeerr != nil {
-			return nil, nil, mimeerr
-		}
-		bi.Size, err = strconv.Atoi64(header.Get("Content-Length"))
-		if err != nil {
-			return nil, nil, err
-		}
-		bi.ContentType = header.Get("Content-Type")
-
-		// Parse the time from the MIME header like:
-		// X-AppEngine-Upload-Creation: 2011-03-15 21:38:34.712136
-		const timeFormat = "2006-01-02 15:04:05"
-		createDate := header.Get("X-AppEngine-Upload-Creation")
-		if len(createDate) >= len(timeFormat) {
-			// Strip off the sub-second precision
-			// because time.Parse can't handle it.
-			bi.CreationTime, err = time.Parse(timeFormat, createDate[:len(timeFormat)])
-			if err != nil {
-				return nil, nil, errorf("error parsing X-AppEngine-Upload-Creation: %s", err)
-			}
-		} else {
-			return nil, nil, errorf("expected to find an X-AppEngine-Upload-Creation header")
+		if err = parseBlobInfo(part, bi); err != nil {
+			return nil, err
 		}
-
 		blobs[formKey] = append(blobs[formKey], bi)
 	}
 	return
 }
 
+// parseBlobInfo fills bi with the blob metadata in the body of a blob MIME
+// part of an upload.
+func parseBlobInfo(part *multipart.Part, bi *BlobInfo) (err error) {
+	// App Engine sends a MIME header as the body of each MIME part.
+	tp := textproto.NewReader(bufio.NewReader(part))
+	header, mimeerr := tp.ReadMIMEHeader()
+	if mimeerr != nil {
+		return mimeerr
+	}
+	bi.Size, err = strconv.Atoi64(header.Get("Content-Length"))
+	if err != nil {
+		return err
+	}
+	bi.ContentType = header.Get("Content-Type")
+
+	// Parse the time from the MIME header like:
+	// X-AppEngine-Upload-Creation: 2011-03-15 21:38:34.712136
+	const timeFormat = "2006-01-02 15:04:05"
+	createDate := header.Get("X-AppEngine-Upload-Creation")
+	if len(createDate) >= len(timeFormat) {
+		// Strip off the sub-second precision
+		// because time.Parse can't handle it.
+		bi.CreationTime, err = time.Parse(timeFormat, createDate[:len(timeFormat)])
+		if err != nil {
+			return errorf("error parsing X-AppEngine-Upload-Creation: %s", err)
+		}
+	} else {
+		return errorf("expected to find an X-AppEngine-Upload-Creation header")
+	}
+	return nil
+}
+
 // Reader is a blob reader.
 type Reader interface {
 	io.Reader
@@ -233,6 +369,60 @@ func NewReader(c appengine.Context, blobKey appengine.BlobKey) Reader {
 	}
 }
 
+// gzipContentTypes are the content types of blobs that NewGzipReader
+// decompresses.
+var gzipContentTypes = map[string]bool{
+	"application/gzip":   true,
+	"application/x-gzip": true,
+}
+
+// NewGzipReader returns a reader for a blob that transparently decompresses
+// it if it is stored gzipped, as indicated by its content type in BlobInfo.
+// Otherwise it returns the same reader as NewReader.
+//
+// The returned reader does not support Seek or ReadAt for gzipped blobs.
+func NewGzipReader(c appengine.Context, blobKey appengine.BlobKey) (Reader, error) {
+	bi, err := Stat(c, blobKey)
+	if err != nil {
+		return nil, err
+	}
+	r := NewReader(c, blobKey)
+	if !gzipContentTypes[bi.ContentType] {
+		return r, nil
+	}
+	z, err := newGzipReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// gzipReader is a Reader that decompresses a gzipped blob.
+type gzipReader struct {
+	z io.Reader
+}
+
+// newGzipReader returns a gzipReader that decompresses r.
+func newGzipReader(r io.Reader) (*gzipReader, error) {
+	z, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, errorf("error reading gzip header: %v", err)
+	}
+	return &gzipReader{z: z}, nil
+}
+
+func (r *gzipReader) Read(p []byte) (int, error) {
+	return r.z.Read(p)
+}
+
+func (r *gzipReader) ReadAt(p []byte, off int64) (int, error) {
+	return 0, errorf("ReadAt isn't supported for gzipped blobs")
+}
+
+func (r *gzipReader) Seek(offset int64, whence int) (int64, error) {
+	return 0, errorf("Seek isn't supported for gzipped blobs")
+}
+
 const readBufferSize = 256 * 1024
 
 // reader is a blob reader. It implements the Reader interface.
@@ -349,7 +539,13 @@ func (r *reader) seek(off int64) (int64, error) {
 	return off, nil
 }
 
-const writeBufferSize = 256 * 1024
+// writeBufferSize is the default size of the Writer buffer, and
+// maxWriteBufferSize the largest one allowed, since the data of an API call
+// is limited.
+const (
+	writeBufferSize    = 256 * 1024
+	maxWriteBufferSize = 1 << 20
+)
 
 // Writer is used for writing blobs. Blobs aren't fully written until
 // Close is called, at which point the key can be retrieved by calling
@@ -359,6 +555,7 @@ type Writer struct {
 	filename string
 
 	buf      []byte
+	bufSize  int   // flush when buf reaches this size
 	writeErr error // set in flush
 
 	// set on Close:
@@ -372,6 +569,11 @@ type Writer struct {
 // Verify that Writer implements the io.WriteCloser interface.
 var _ io.WriteCloser = (*Writer)(nil)
 
+// Verify that Writer implements WriteString, as used by io.WriteString.
+var _ interface {
+	WriteString(s string) (int, error)
+} = (*Writer)(nil)
+
 // Prefix for all blobstore-based files.
 const blobstoreFileDirectory = "/blobstore/"
 
@@ -387,9 +589,37 @@ const creationHandlePrefix = "writable:"
 // then closed, and then its Key method can be called to retrieve the
 // newly-created blob key if there were no errors.
 func Create(c appengine.Context, mimeType string) (*Writer, error) {
+	return CreateWithOptions(c, &CreateOptions{MIMEType: mimeType})
+}
+
+// CreateOptions are the options to create a blob.
+type CreateOptions struct {
+	MIMEType string // optional, defaults to application/octet-stream
+	Filename string // optional, stored as the BlobInfo's Filename
+
+	// BufferSize is the number of bytes the Writer buffers before sending
+	// them to the blobstore. Larger buffers make fewer API calls when
+	// writing large blobs. It defaults to 256KB and can't exceed 1MB.
+	BufferSize int
+}
+
+// CreateWithOptions is like Create, but also allows setting the filename
+// stored in the blob's BlobInfo. The opts parameter may be nil.
+func CreateWithOptions(c appengine.Context, opts *CreateOptions) (*Writer, error) {
+	if opts == nil {
+		opts = &CreateOptions{}
+	}
+	mimeType := opts.MIMEType
 	if mimeType == "" {
 		mimeType = "application/octet-stream"
 	}
+	bufSize := opts.BufferSize
+	if bufSize == 0 {
+		bufSize = writeBufferSize
+	}
+	if bufSize < 0 || bufSize > maxWriteBufferSize {
+		return nil, errorf("buffer size %d is not between 1 and %d bytes", bufSize, maxWriteBufferSize)
+	}
 	req := &files.CreateRequest{
 		Filesystem:  proto.String("blobstore"),
 		ContentType: files.NewFileContentType_ContentType(files.FileContentType_RAW),
@@ -399,6 +629,15 @@ func Create(c appengine.Context, mimeType string) (*Writer, error) {
 				Value: proto.String(mimeType),
 			}},
 	}
+	if opts.Filename != "" {
+		if strings.TrimSpace(opts.Filename) == "" {
+			return nil, errorf("blank filename %q", opts.Filename)
+		}
+		req.Parameters = append(req.Parameters, &files.CreateRequest_Parameter{
+			Name:  proto.String("file_name"),
+			Value: proto.String(opts.Filename),
+		})
+	}
 	res := &files.CreateResponse{}
 	if err := c.Call("file", "Create", req, res, nil); err != nil {
 		return nil, err
@@ -407,6 +646,7 @@ func Create(c appengine.Context, mimeType string) (*Writer, error) {
 	w := &Writer{
 		c:        c,
 		filename: *res.Filename,
+		bufSize:  bufSize,
 	}
 	if !strings.HasPrefix(w.filename, blobstoreFileDirectory) {
 		return nil, errorf("unexpected filename from files service")
@@ -430,26 +670,48 @@ func (w *Writer) Write(p []byte) (n int, err error) {
 		return 0, errorf("Writer is already closed")
 	}
 	w.buf = append(w.buf, p...)
-	if len(w.buf) >= writeBufferSize {
+	return w.buffered(len(p))
+}
+
+// WriteString is like Write, but writes the contents of s, without
+// converting it to a []byte first.
+func (w *Writer) WriteString(s string) (n int, err error) {
+	if w.closed {
+		return 0, errorf("Writer is already closed")
+	}
+	w.buf = append(w.buf, s...)
+	return w.buffered(len(s))
+}
+
+// buffered flushes the buffer if it is full, after n bytes were added to it,
+// and returns the result of the write.
+func (w *Writer) buffered(n int) (int, error) {
+	if len(w.buf) >= w.bufSize {
 		w.flush()
 		if w.writeErr != nil {
 			return 0, w.writeErr
 		}
 	}
-	return len(p), nil
+	return n, nil
 }
 
+// flush appends the buffered data to the blob file, in calls of at most
+// bufSize bytes, so that a large Write doesn't exceed the API call limit.
 func (w *Writer) flush() {
-	if len(w.buf) == 0 {
-		return
-	}
-	req := &files.AppendRequest{
-		Filename: proto.String(w.filename),
-		Data:     w.buf,
-	}
-	res := &files.AppendResponse{}
-	if err := w.c.Call("file", "Append", req, res, nil); err != nil {
-		w.writeErr = err
+	for len(w.buf) > 0 && w.writeErr == nil {
+		n := len(w.buf)
+		if n > w.bufSize {
+			n = w.bufSize
+		}
+		req := &files.AppendRequest{
+			Filename: proto.String(w.filename),
+			Data:     w.buf[:n],
+		}
+		res := &files.AppendResponse{}
+		if err := w.c.Call("file", "Append", req, res, nil); err != nil {
+			w.writeErr = err
+		}
+		w.buf = w.buf[n:]
 	}
 	w.buf = nil
 }
@@ -0,0 +1,472 @@
+// Copyright 2011 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package blobstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"http"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"appengine"
+	"appengine_internal"
+	"appengine_internal/files"
+	"goprotobuf.googlecode.com/hg/proto"
+
+	blobstore_proto "appengine_internal/blobstore"
+	datastore_proto "appengine_internal/datastore"
+)
+
+func TestGzipReader(t *testing.T) {
+	const want = "hello, gzipped blob"
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write([]byte(want))
+	zw.Close()
+
+	r, err := newGzipReader(&buf)
+	if err != nil {
+		t.Fatalf("newGzipReader: %v", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := r.Seek(0, os.SEEK_SET); err == nil {
+		t.Errorf("expected Seek to fail")
+	}
+	if _, err := r.ReadAt(make([]byte, 1), 0); err == nil {
+		t.Errorf("expected ReadAt to fail")
+	}
+}
+
+func TestGzipReaderBadHeader(t *testing.T) {
+	if _, err := newGzipReader(bytes.NewBufferString("not gzipped")); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestNewGzipReader(t *testing.T) {
+	const want = "hello, gzipped blob"
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write([]byte(want))
+	zw.Close()
+	c := &fakeContext{
+		entities: []*datastore_proto.EntityProto{
+			blobInfoEntity("z", "application/x-gzip", "z.txt.gz", int64(buf.Len())),
+			blobInfoEntity("p", "text/plain", "p.txt", int64(len(want))),
+		},
+		blobs: map[string][]byte{
+			"z": buf.Bytes(),
+			"p": []byte(want),
+		},
+	}
+
+	for _, blobKey := range []appengine.BlobKey{"z", "p"} {
+		r, err := NewGzipReader(c, blobKey)
+		if err != nil {
+			t.Fatalf("%s: NewGzipReader: %v", blobKey, err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: ReadAll: %v", blobKey, err)
+		}
+		if string(got) != want {
+			t.Errorf("%s: got %q, want %q", blobKey, got, want)
+		}
+		// Only the reader of a blob that isn't gzipped can seek.
+		_, err = r.Seek(0, os.SEEK_SET)
+		if gzipped := blobKey == "z"; gzipped != (err != nil) {
+			t.Errorf("%s: Seek: got error %v", blobKey, err)
+		}
+	}
+
+	// A gzip content type with data that isn't gzipped fails up front.
+	c.blobs["z"] = []byte(want)
+	if _, err := NewGzipReader(c, "z"); err == nil {
+		t.Errorf("expected an error for a bad gzip header")
+	}
+	if _, err := NewGzipReader(c, "missing"); err == nil {
+		t.Errorf("expected an error for a missing blob")
+	}
+}
+
+// fakeContext is an appengine.Context that answers datastore queries and
+// gets with a fixed list of __BlobInfo__ entities, recording each query it
+// receives, serves the data of blobs, and accepts file creation, upload URL
+// and deletion requests, recording each of them.
+type fakeContext struct {
+	entities   []*datastore_proto.EntityProto
+	blobs      map[string][]byte
+	queries    []*datastore_proto.Query
+	creates    []*files.CreateRequest
+	uploadURLs []*blobstore_proto.CreateUploadURLRequest
+	deleted    []string
+	appended   [][]byte
+}
+
+func (c *fakeContext) Debugf(format string, args ...interface{})    {}
+func (c *fakeContext) Infof(format string, args ...interface{})     {}
+func (c *fakeContext) Warningf(format string, args ...interface{})  {}
+func (c *fakeContext) Errorf(format string, args ...interface{})    {}
+func (c *fakeContext) Criticalf(format string, args ...interface{}) {}
+func (c *fakeContext) AppID() string                                { return "app" }
+func (c *fakeContext) FullyQualifiedAppID() string                  { return "dev~app" }
+func (c *fakeContext) Request() interface{}                         { return nil }
+
+func (c *fakeContext) Call(service, method string, in, out interface{}, opts *appengine_internal.CallOptions) error {
+	switch in := in.(type) {
+	case *files.CreateRequest:
+		c.creates = append(c.creates, in)
+		out.(*files.CreateResponse).Filename = proto.String(blobstoreFileDirectory + "new")
+		return nil
+	case *files.OpenRequest, *files.CloseRequest:
+		return nil
+	case *files.AppendRequest:
+		c.appended = append(c.appended, in.Data)
+		return nil
+	case *blobstore_proto.CreateUploadURLRequest:
+		c.uploadURLs = append(c.uploadURLs, in)
+		out.(*blobstore_proto.CreateUploadURLResponse).Url = proto.String("http://localhost/_ah/upload/x")
+		return nil
+	case *blobstore_proto.DeleteBlobRequest:
+		c.deleted = append(c.deleted, in.BlobKey...)
+		return nil
+	case *blobstore_proto.FetchDataRequest:
+		data := c.blobs[proto.GetString(in.BlobKey)]
+		start, end := proto.GetInt64(in.StartIndex), proto.GetInt64(in.EndIndex)+1
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+		if start < end {
+			out.(*blobstore_proto.FetchDataResponse).Data = data[start:end]
+		}
+		return nil
+	case *datastore_proto.GetRequest:
+		res := out.(*datastore_proto.GetResponse)
+		for _, k := range in.Key {
+			var found *datastore_proto.EntityProto
+			for _, e := range c.entities {
+				if proto.GetString(e.Key.Path.Element[0].Name) == proto.GetString(k.Path.Element[0].Name) {
+					found = e
+				}
+			}
+			res.Entity = append(res.Entity, &datastore_proto.GetResponse_Entity{Entity: found})
+		}
+		return nil
+	}
+	q := in.(*datastore_proto.Query)
+	c.queries = append(c.queries, q)
+	res := out.(*datastore_proto.QueryResult)
+	res.Result = c.entities
+	res.MoreResults = proto.Bool(false)
+	if proto.GetBool(q.Compile) {
+		res.CompiledCursor = &datastore_proto.CompiledCursor{
+			Position: []*datastore_proto.CompiledCursor_Position{
+				&datastore_proto.CompiledCursor_Position{StartKey: proto.String("next")},
+			},
+		}
+	}
+	return nil
+}
+
+func blobInfoEntity(blobKey, contentType, filename string, size int64) *datastore_proto.EntityProto {
+	property := func(name string, v *datastore_proto.PropertyValue) *datastore_proto.Property {
+		return &datastore_proto.Property{
+			Name:     proto.String(name),
+			Value:    v,
+			Multiple: proto.Bool(false),
+		}
+	}
+	creation := property("creation", &datastore_proto.PropertyValue{Int64Value: proto.Int64(size)})
+	creation.Meaning = datastore_proto.NewProperty_Meaning(datastore_proto.Property_GD_WHEN)
+	return &datastore_proto.EntityProto{
+		Key: &datastore_proto.Reference{
+			App: proto.String("dev~app"),
+			Path: &datastore_proto.Path{
+				Element: []*datastore_proto.Path_Element{
+					&datastore_proto.Path_Element{
+						Type: proto.String(blobInfoKind),
+						Name: proto.String(blobKey),
+					},
+				},
+			},
+		},
+		EntityGroup: &datastore_proto.Path{},
+		Property: []*datastore_proto.Property{
+			property("content_type", &datastore_proto.PropertyValue{StringValue: proto.String(contentType)}),
+			property("filename", &datastore_proto.PropertyValue{StringValue: proto.String(filename)}),
+			property("size", &datastore_proto.PropertyValue{Int64Value: proto.Int64(size)}),
+			creation,
+		},
+	}
+}
+
+func TestList(t *testing.T) {
+	c := &fakeContext{
+		entities: []*datastore_proto.EntityProto{
+			blobInfoEntity("a", "text/plain", "a.txt", 1),
+			blobInfoEntity("b", "text/plain", "b.txt", 2),
+			blobInfoEntity("c", "text/plain", "c.txt", 3),
+		},
+	}
+	infos, cursor, err := List(c, &ListOptions{Limit: 3})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(infos) != 3 {
+		t.Fatalf("got %d blobs, want 3", len(infos))
+	}
+	for i, want := range []string{"a", "b", "c"} {
+		bi := infos[i]
+		if string(bi.BlobKey) != want || bi.Filename != want+".txt" || bi.Size != int64(i+1) {
+			t.Errorf("blob %d: got %+v", i, bi)
+		}
+	}
+	q := c.queries[0]
+	if proto.GetString(q.Kind) != blobInfoKind || proto.GetInt32(q.Limit) != 3 {
+		t.Errorf("query: got kind %q, limit %d", proto.GetString(q.Kind), proto.GetInt32(q.Limit))
+	}
+	if len(q.Order) != 1 || proto.GetString(q.Order[0].Property) != "creation" {
+		t.Errorf("query: got order %v, want creation", q.Order)
+	}
+
+	// The cursor returned by the first page starts the second one.
+	c.entities = nil
+	if _, _, err := List(c, &ListOptions{Start: cursor}); err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if cc := c.queries[1].CompiledCursor; cc == nil || proto.GetString(cc.Position[0].StartKey) != "next" {
+		t.Errorf("second page: got cursor %v", cc)
+	}
+	if _, _, err := List(c, nil); err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if c.queries[2].CompiledCursor != nil {
+		t.Errorf("first page: got cursor %v, want none", c.queries[2].CompiledCursor)
+	}
+}
+
+func TestCreateFilename(t *testing.T) {
+	c := &fakeContext{}
+	if _, err := CreateWithOptions(c, &CreateOptions{Filename: "report.csv"}); err != nil {
+		t.Fatalf("CreateWithOptions: %v", err)
+	}
+	if _, err := Create(c, "text/plain"); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	params := func(req *files.CreateRequest) map[string]string {
+		m := make(map[string]string)
+		for _, p := range req.Parameters {
+			m[proto.GetString(p.Name)] = proto.GetString(p.Value)
+		}
+		return m
+	}
+	p := params(c.creates[0])
+	if p["file_name"] != "report.csv" || p["content_type"] != "application/octet-stream" {
+		t.Errorf("parameters: got %v", p)
+	}
+	if p := params(c.creates[1]); p["content_type"] != "text/plain" || len(p) != 1 {
+		t.Errorf("parameters: got %v", p)
+	}
+
+	if _, err := CreateWithOptions(c, &CreateOptions{Filename: " "}); err == nil {
+		t.Errorf("expected an error for a blank filename")
+	}
+	if len(c.creates) != 2 {
+		t.Errorf("got %d create calls, want 2", len(c.creates))
+	}
+}
+
+func TestWriterBufferSize(t *testing.T) {
+	line := strings.Repeat("x", 1023) + "\n"
+	for _, test := range []struct {
+		bufSize, appends int
+	}{
+		{0, 4},
+		{512 * 1024, 2},
+		{maxWriteBufferSize, 1},
+	} {
+		c := &fakeContext{}
+		w, err := CreateWithOptions(c, &CreateOptions{BufferSize: test.bufSize})
+		if err != nil {
+			t.Fatalf("CreateWithOptions: %v", err)
+		}
+		for i := 0; i < 1024; i++ {
+			if n, err := io.WriteString(w, line); n != len(line) || err != nil {
+				t.Fatalf("WriteString: got %d, %v", n, err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		if len(c.appended) != test.appends {
+			t.Errorf("buffer size %d: got %d Append calls, want %d", test.bufSize, len(c.appended), test.appends)
+		}
+		var written []byte
+		for _, data := range c.appended {
+			written = append(written, data...)
+		}
+		if string(written) != strings.Repeat(line, 1024) {
+			t.Errorf("buffer size %d: got %d bytes, want %d", test.bufSize, len(written), 1024*len(line))
+		}
+	}
+
+	// A write larger than the buffer is sent in calls of at most its size.
+	c := &fakeContext{}
+	w, err := CreateWithOptions(c, &CreateOptions{BufferSize: 4096})
+	if err != nil {
+		t.Fatalf("CreateWithOptions: %v", err)
+	}
+	if n, err := w.Write(make([]byte, 10000)); n != 10000 || err != nil {
+		t.Fatalf("Write: got %d, %v", n, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	var sizes []int
+	for _, data := range c.appended {
+		sizes = append(sizes, len(data))
+	}
+	if want := []int{4096, 4096, 1808}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("large write: got Append sizes %v, want %v", sizes, want)
+	}
+
+	for _, size := range []int{-1, maxWriteBufferSize + 1} {
+		if _, err := CreateWithOptions(&fakeContext{}, &CreateOptions{BufferSize: size}); err == nil {
+			t.Errorf("buffer size %d: expected an error", size)
+		}
+	}
+}
+
+// countingWriter counts the bytes written to it, discarding them.
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+func TestParseUploadStream(t *testing.T) {
+	const (
+		boundary = "b0und4ry"
+		size     = 4 << 20
+	)
+	head := "--" + boundary + "\r\n" +
+		"Content-Type: message/external-body; blob-key=\"key1\"\r\n" +
+		"Content-Disposition: form-data; name=\"file\"; filename=\"a.txt\"\r\n" +
+		"\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Content-Length: 42\r\n" +
+		"X-AppEngine-Upload-Creation: 2011-03-15 21:38:34.712136\r\n" +
+		"\r\n" +
+		"\r\n--" + boundary + "\r\n" +
+		"Content-Disposition: form-data; name=\"big\"\r\n" +
+		"\r\n"
+	tail := "\r\n--" + boundary + "--\r\n"
+	body := io.MultiReader(
+		strings.NewReader(head),
+		io.LimitReader(xReader{}, size),
+		strings.NewReader(tail),
+	)
+	req, err := http.NewRequest("POST", "http://example.com/upload", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "multipart/form-data; boundary="+boundary)
+
+	var n countingWriter
+	blobs, err := ParseUploadStream(req, func(formKey string, part *multipart.Part) error {
+		if formKey != "big" {
+			t.Errorf("formKey: got %q, want big", formKey)
+		}
+		_, err := io.Copy(&n, part)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("ParseUploadStream: %v", err)
+	}
+	if n != size {
+		t.Errorf("streamed %d bytes, want %d", n, size)
+	}
+	bi := blobs["file"]
+	if len(bi) != 1 || bi[0].BlobKey != "key1" || bi[0].Filename != "a.txt" || bi[0].Size != 42 {
+		t.Errorf("blobs: got %v", blobs)
+	}
+}
+
+// xReader is an endless stream of 'x' bytes.
+type xReader struct{}
+
+func (xReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+	return len(p), nil
+}
+
+func TestUploadURLBucket(t *testing.T) {
+	c := new(fakeContext)
+	for _, bucket := range []string{"my-bucket", ""} {
+		if _, err := UploadURL(c, "/done", &UploadURLOptions{GSBucketName: bucket}); err != nil {
+			t.Fatalf("UploadURL: %v", err)
+		}
+	}
+	if got := proto.GetString(c.uploadURLs[0].GsBucketName); got != "my-bucket" {
+		t.Errorf("bucket name: got %q, want %q", got, "my-bucket")
+	}
+	if c.uploadURLs[1].GsBucketName != nil {
+		t.Errorf("bucket name: got %q, want it unset", *c.uploadURLs[1].GsBucketName)
+	}
+}
+
+func TestDeleteByPrefix(t *testing.T) {
+	// The fake doesn't filter: it returns the entities the datastore would,
+	// in filename order from the prefix on.
+	c := &fakeContext{
+		entities: []*datastore_proto.EntityProto{
+			blobInfoEntity("a", "text/plain", "logs/1.txt", 1),
+			blobInfoEntity("b", "text/plain", "logs/2.txt", 2),
+			blobInfoEntity("c", "text/plain", "photos/1.jpg", 3),
+		},
+	}
+	n, err := DeleteByPrefix(c, "logs/")
+	if err != nil {
+		t.Fatalf("DeleteByPrefix: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("count: got %d, want 2", n)
+	}
+	if len(c.deleted) != 2 || c.deleted[0] != "a" || c.deleted[1] != "b" {
+		t.Errorf("deleted: got %v, want [a b]", c.deleted)
+	}
+	q := c.queries[0]
+	if len(q.Filter) != 1 || proto.GetString(q.Filter[0].Property[0].Value.StringValue) != "logs/" {
+		t.Errorf("query: got filters %v", q.Filter)
+	}
+
+	if _, err := DeleteByPrefix(c, ""); err == nil {
+		t.Errorf("expected an error for an empty prefix")
+	}
+	if len(c.queries) != 1 || len(c.deleted) != 2 {
+		t.Errorf("empty prefix: got %d queries and %d deletions", len(c.queries), len(c.deleted))
+	}
+}
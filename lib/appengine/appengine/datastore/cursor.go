@@ -0,0 +1,100 @@
+// Copyright 2011 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package datastore
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"goprotobuf.googlecode.com/hg/proto"
+
+	pb "appengine_internal/datastore"
+)
+
+// Cursor is a position in the results of a query. The zero value is the
+// start of the results.
+type Cursor struct {
+	cc *pb.CompiledCursor
+}
+
+// Encode returns an opaque representation of the cursor suitable for use in
+// HTML and URLs, such as to pass a position between requests. It is the
+// base64url encoding of the cursor's protocol buffer, so it stays valid
+// across application versions. The zero Cursor encodes as "".
+func (c Cursor) Encode() string {
+	if c.cc == nil {
+		return ""
+	}
+	b, err := proto.Marshal(c.cc)
+	if err != nil {
+		panic(err)
+	}
+	// Trailing padding is stripped.
+	return strings.TrimRight(base64.URLEncoding.EncodeToString(b), "=")
+}
+
+// errInvalidCursor is returned by DecodeCursor for malformed input.
+var errInvalidCursor = errors.New("datastore: invalid encoded cursor")
+
+// DecodeCursor decodes a cursor from the opaque representation returned by
+// Encode. It returns an error for the empty string and for input that wasn't
+// returned by Encode.
+func DecodeCursor(encoded string) (Cursor, error) {
+	// Re-add padding.
+	if m := len(encoded) % 4; m != 0 {
+		encoded += strings.Repeat("=", 4-m)
+	}
+
+	b, err := base64.URLEncoding.DecodeString(encoded)
+	if err != nil || len(b) == 0 {
+		return Cursor{}, errInvalidCursor
+	}
+
+	cc := new(pb.CompiledCursor)
+	if err := proto.Unmarshal(b, cc); err != nil {
+		return Cursor{}, errInvalidCursor
+	}
+	// Reject anything that parsed but doesn't look like a query position,
+	// rather than failing later when the query is sent.
+	if len(cc.Position) == 0 || cc.XXX_unrecognized != nil {
+		return Cursor{}, errInvalidCursor
+	}
+	return Cursor{cc}, nil
+}
+
+// Start sets the position at which the query starts returning results, as
+// given by a cursor returned from a previous run of the same query.
+// A zero Cursor means the start of the results.
+func (q *Query) Start(c Cursor) *Query {
+	q.start = c.cc
+	return q
+}
+
+// KeepCursor makes the query ask the datastore for a cursor, so that
+// Iterator.Cursor can return one after the query is run. Queries don't
+// compile a cursor otherwise.
+func (q *Query) KeepCursor() *Query {
+	q.compile = true
+	return q
+}
+
+// Cursor returns a cursor positioned after the results fetched so far, which
+// can be passed to Query.Start to continue the same query from there. The
+// query must have been set up with KeepCursor.
+// Results are fetched in batches, so the cursor is only accurate once Next
+// has returned Done, such as at the end of a query with a limit.
+func (t *Iterator) Cursor() (Cursor, error) {
+	if t.err != nil && t.err != Done {
+		return Cursor{}, t.err
+	}
+	if t.seen != nil {
+		return Cursor{}, errors.New("datastore: cursors are not supported for queries with Or")
+	}
+	if t.res.CompiledCursor == nil {
+		return Cursor{}, errors.New("datastore: no cursor; run the query with KeepCursor")
+	}
+	return Cursor{t.res.CompiledCursor}, nil
+}
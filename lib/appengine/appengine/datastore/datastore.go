@@ -43,10 +43,94 @@ func (t Time) Time() *time.Time {
 	return time.SecondsToUTC(int64(t) / 1e6)
 }
 
+// TimesToTimes converts a slice of datastore times, such as a loaded
+// []Time field, to a slice of *time.Time.
+func TimesToTimes(ts []Time) []*time.Time {
+	times := make([]*time.Time, len(ts))
+	for i, t := range ts {
+		times[i] = t.Time()
+	}
+	return times
+}
+
+// TimesFromTimes converts a slice of *time.Time to a slice of datastore
+// times, to be saved as a []Time field. Like Time, it drops subseconds.
+func TimesFromTimes(times []*time.Time) []Time {
+	ts := make([]Time, len(times))
+	for i, t := range times {
+		ts[i] = SecondsToTime(t.Seconds())
+	}
+	return ts
+}
+
+// Email, Link, Category and Rating are distinct types so that properties
+// holding an email address, a URL, a category name or a rating from 0 to 100
+// are displayed as such in App Engine tools like the Admin Console.
+// When loaded into a Map, these properties are plain string and int64
+// values.
+type (
+	Email    string
+	Link     string
+	Category string
+	Rating   int64
+)
+
 // Map is a map representation of an entity's fields. It is more flexible than
 // but not as strongly typed as a struct representation.
 type Map map[string]interface{}
 
+// The following methods return a Map entry of a given type, and whether the
+// Map has an entry of that type for the name. They save a type assertion
+// when reading loaded entities.
+
+// Int64 returns the int64 entry for name.
+func (m Map) Int64(name string) (int64, bool) {
+	v, ok := m[name].(int64)
+	return v, ok
+}
+
+// Bool returns the bool entry for name.
+func (m Map) Bool(name string) (bool, bool) {
+	v, ok := m[name].(bool)
+	return v, ok
+}
+
+// String returns the string entry for name.
+func (m Map) String(name string) (string, bool) {
+	v, ok := m[name].(string)
+	return v, ok
+}
+
+// Float64 returns the float64 entry for name.
+func (m Map) Float64(name string) (float64, bool) {
+	v, ok := m[name].(float64)
+	return v, ok
+}
+
+// Bytes returns the []byte entry for name.
+func (m Map) Bytes(name string) ([]byte, bool) {
+	v, ok := m[name].([]byte)
+	return v, ok
+}
+
+// Key returns the *Key entry for name.
+func (m Map) Key(name string) (*Key, bool) {
+	v, ok := m[name].(*Key)
+	return v, ok
+}
+
+// Time returns the Time entry for name.
+func (m Map) Time(name string) (Time, bool) {
+	v, ok := m[name].(Time)
+	return v, ok
+}
+
+// BlobKey returns the appengine.BlobKey entry for name.
+func (m Map) BlobKey(name string) (appengine.BlobKey, bool) {
+	v, ok := m[name].(appengine.BlobKey)
+	return v, ok
+}
+
 var (
 	// ErrInvalidEntityType is returned when an invalid destination entity type
 	// is passed to Get, GetAll, GetMulti or Next.
@@ -82,6 +166,21 @@ func (e *ErrFieldMismatch) Error() string {
 		e.FieldName, e.Key, e.StructType, e.Reason)
 }
 
+// ErrTooManyIndexedProperties is returned when an entity to be saved has more
+// indexed properties than the datastore allows. Each element of a slice-valued
+// field counts as one indexed property; unindexed properties don't count.
+// TypeName is the type of the struct, or "datastore.Map", being saved.
+type ErrTooManyIndexedProperties struct {
+	TypeName string
+	Count    int
+}
+
+// Error returns a string representation of the error.
+func (e *ErrTooManyIndexedProperties) Error() string {
+	return fmt.Sprintf("datastore: cannot save a %q with %d indexed properties: the limit is %d",
+		e.TypeName, e.Count, maxIndexedProperties)
+}
+
 // ErrMulti indicates that a batch operation failed on at least one element.
 type ErrMulti []error
 
@@ -111,13 +210,15 @@ func (m ErrMulti) Error() string {
 // protoToKey converts a Reference proto to a *Key.
 func protoToKey(r *pb.Reference) (k *Key, err error) {
 	appID := proto.GetString(r.App)
+	namespace := proto.GetString(r.NameSpace)
 	for _, e := range r.Path.Element {
 		k = &Key{
-			kind:     proto.GetString(e.Type),
-			stringID: proto.GetString(e.Name),
-			intID:    proto.GetInt64(e.Id),
-			parent:   k,
-			appID:    appID,
+			kind:      proto.GetString(e.Type),
+			stringID:  proto.GetString(e.Name),
+			intID:     proto.GetInt64(e.Id),
+			parent:    k,
+			appID:     appID,
+			namespace: namespace,
 		}
 		if !k.valid() {
 			return nil, ErrInvalidKey
@@ -150,12 +251,16 @@ func keyToProto(defaultAppID string, k *Key) *pb.Reference {
 			e[n].Id = &i.intID
 		}
 	}
-	return &pb.Reference{
+	ref := &pb.Reference{
 		App: proto.String(appID),
 		Path: &pb.Path{
 			Element: e,
 		},
 	}
+	if k.namespace != "" {
+		ref.NameSpace = proto.String(k.namespace)
+	}
+	return ref
 }
 
 // multiKeyToProto is a batch version of keyToProto.
@@ -189,6 +294,31 @@ func multiValid(key []*Key) error {
 	return err
 }
 
+// multiValidPut is like multiValid, but also checks that each src is a
+// struct pointer or a Map. Invalid keys are reported as ErrInvalidKey and
+// invalid sources as ErrInvalidEntityType.
+func multiValidPut(key []*Key, src []interface{}) error {
+	var err ErrMulti
+	for i, k := range key {
+		var e error
+		if !k.valid() {
+			e = ErrInvalidKey
+		} else if _, ok := src[i].(Map); !ok {
+			_, e = asStructValue(src[i])
+		}
+		if e != nil {
+			if err == nil {
+				err = make(ErrMulti, len(key))
+			}
+			err[i] = e
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	return err
+}
+
 // It's unfortunate that the two semantically equivalent concepts pb.Reference
 // and pb.PropertyValue_ReferenceValue aren't the same type. For example, the
 // two have different protobuf field numbers.
@@ -197,13 +327,15 @@ func multiValid(key []*Key) error {
 // PropertyValue_ReferenceValue instead of a Reference.
 func referenceValueToKey(r *pb.PropertyValue_ReferenceValue) (k *Key, err error) {
 	appID := proto.GetString(r.App)
+	namespace := proto.GetString(r.NameSpace)
 	for _, e := range r.Pathelement {
 		k = &Key{
-			kind:     proto.GetString(e.Type),
-			stringID: proto.GetString(e.Name),
-			intID:    proto.GetInt64(e.Id),
-			parent:   k,
-			appID:    appID,
+			kind:      proto.GetString(e.Type),
+			stringID:  proto.GetString(e.Name),
+			intID:     proto.GetInt64(e.Id),
+			parent:    k,
+			appID:     appID,
+			namespace: namespace,
 		}
 		if !k.valid() {
 			return nil, ErrInvalidKey
@@ -226,6 +358,7 @@ func keyToReferenceValue(defaultAppID string, k *Key) *pb.PropertyValue_Referenc
 	}
 	return &pb.PropertyValue_ReferenceValue{
 		App:         ref.App,
+		NameSpace:   ref.NameSpace,
 		Pathelement: pe,
 	}
 }
@@ -259,8 +392,32 @@ func Get(c appengine.Context, key *Key, dst interface{}) error {
 	return err
 }
 
+// GetOptions are the options for GetWithOptions.
+type GetOptions struct {
+	// IgnoreUnknownFields ignores stored properties that have no field in
+	// the destination struct, instead of returning ErrFieldMismatch. This
+	// allows loading entities saved with fields that were since removed.
+	// Properties that can't be loaded into an existing field are still
+	// reported.
+	IgnoreUnknownFields bool
+}
+
+// GetWithOptions is like Get, with options. The opts parameter may be nil.
+func GetWithOptions(c appengine.Context, key *Key, dst interface{}, opts *GetOptions) error {
+	err := getMulti(c, []*Key{key}, []interface{}{dst}, opts)
+	if errMulti, ok := err.(ErrMulti); ok {
+		return errMulti[0]
+	}
+	return err
+}
+
 // GetMulti is a batch version of Get.
 func GetMulti(c appengine.Context, key []*Key, dst []interface{}) error {
+	return getMulti(c, key, dst, nil)
+}
+
+// getMulti is GetMulti with options. The opts parameter may be nil.
+func getMulti(c appengine.Context, key []*Key, dst []interface{}, opts *GetOptions) error {
 	if len(key) != len(dst) {
 		return errors.New("datastore: key and dst slices have different length")
 	}
@@ -296,7 +453,7 @@ func GetMulti(c appengine.Context, key []*Key, dst []interface{}) error {
 			errMulti[i] = err
 			continue
 		}
-		errMulti[i] = loadStruct(sv, key[i], e.Entity)
+		errMulti[i] = loadStructWithOptions(sv, key[i], e.Entity, opts)
 	}
 	for _, e := range errMulti {
 		if e != nil {
@@ -306,6 +463,77 @@ func GetMulti(c appengine.Context, key []*Key, dst []interface{}) error {
 	return nil
 }
 
+// GetAllByKey is like GetMulti, but loads the entities into a slice instead
+// of a []interface{}. The dst must be a pointer to a slice of structs, struct
+// pointers, or Maps, as for Query.GetAll. The slice is set to a new slice of
+// len(key) elements, so that the i'th element is loaded from key[i].
+func GetAllByKey(c appengine.Context, key []*Key, dst interface{}) error {
+	dv := reflect.ValueOf(dst)
+	if dv.Kind() != reflect.Ptr || dv.Elem().Kind() != reflect.Slice || dv.IsNil() {
+		return ErrInvalidEntityType
+	}
+	dv = dv.Elem()
+	et := dv.Type().Elem()
+	isMap := et == reflect.TypeOf(Map(nil))
+	isPtr := et.Kind() == reflect.Ptr && et.Elem().Kind() == reflect.Struct
+	if !isMap && !isPtr && et.Kind() != reflect.Struct {
+		return ErrInvalidEntityType
+	}
+	slice := reflect.MakeSlice(dv.Type(), len(key), len(key))
+	elems := make([]interface{}, len(key))
+	for i := range elems {
+		ev := slice.Index(i)
+		switch {
+		case isMap:
+			ev.Set(reflect.ValueOf(make(Map)))
+		case isPtr:
+			ev.Set(reflect.New(et.Elem()))
+		default:
+			ev = ev.Addr()
+		}
+		elems[i] = ev.Interface()
+	}
+	dv.Set(slice)
+	return GetMulti(c, key, elems)
+}
+
+// GetOrInsert loads the entity stored for key into dst, like Get. If there is
+// no such entity, it sets dst to its zero value, an empty struct or Map, and
+// saves it under key instead. It returns whether the entity was created.
+//
+// The get and the put run in a transaction, so that concurrent calls create
+// the entity only once. If c is already a transaction context, they run in
+// that transaction.
+func GetOrInsert(c appengine.Context, key *Key, dst interface{}) (created bool, err error) {
+	f := func(tc appengine.Context) error {
+		created = false
+		err := Get(tc, key, dst)
+		if err != ErrNoSuchEntity {
+			return err
+		}
+		if m, ok := dst.(Map); ok {
+			for k := range m {
+				delete(m, k)
+			}
+		} else if sv, err := asStructValue(dst); err != nil {
+			return err
+		} else {
+			sv.Set(reflect.Zero(sv.Type()))
+		}
+		if _, err := Put(tc, key, dst); err != nil {
+			return err
+		}
+		created = true
+		return nil
+	}
+	if _, ok := c.(*transaction); ok {
+		err = f(c)
+	} else {
+		err = RunInTransaction(c, f, nil)
+	}
+	return created, err
+}
+
 // Put saves the entity src into the datastore with key k. src may be either a
 // struct pointer or a Map; if the former then any unexported fields of that
 // struct will be skipped.
@@ -323,7 +551,28 @@ func Put(c appengine.Context, key *Key, src interface{}) (*Key, error) {
 }
 
 // PutMulti is a batch version of Put.
+// Keys and sources are checked before anything is saved: if any is invalid,
+// PutMulti returns an ErrMulti reporting each invalid element, with
+// ErrInvalidKey or ErrInvalidEntityType.
+// The returned keys are in the order of key: complete keys are returned as
+// is, and incomplete keys are replaced by the keys allocated for them.
 func PutMulti(c appengine.Context, key []*Key, src []interface{}) ([]*Key, error) {
+	return PutMultiWithOptions(c, key, src, nil)
+}
+
+// PutOptions are the options for PutMultiWithOptions.
+type PutOptions struct {
+	// Unindexed stores all properties unindexed, as if every field was
+	// tagged with "noindex". This speeds up bulk imports, since no index
+	// rows are written, but the entities won't be returned by queries that
+	// filter or sort on their properties until they are saved again with
+	// indexed properties.
+	Unindexed bool
+}
+
+// PutMultiWithOptions is like PutMulti, with options. The opts parameter may
+// be nil.
+func PutMultiWithOptions(c appengine.Context, key []*Key, src []interface{}, opts *PutOptions) ([]*Key, error) {
 	if len(key) != len(src) {
 		return nil, errors.New("datastore: key and src slices have different length")
 	}
@@ -331,28 +580,17 @@ func PutMulti(c appengine.Context, key []*Key, src []interface{}) ([]*Key, error
 		return nil, nil
 	}
 	appID := c.FullyQualifiedAppID()
-	if err := multiValid(key); err != nil {
+	if err := multiValidPut(key, src); err != nil {
 		return nil, err
 	}
+	noIndex := opts != nil && opts.Unindexed
 	req := &pb.PutRequest{}
 	for i, sIface := range src {
-		if m, ok := sIface.(Map); ok {
-			sProto, err := saveMap(appID, key[i], m)
-			if err != nil {
-				return nil, err
-			}
-			req.Entity = append(req.Entity, sProto)
-		} else {
-			sv, err := asStructValue(sIface)
-			if err != nil {
-				return nil, err
-			}
-			sProto, err := saveStruct(appID, key[i], sv)
-			if err != nil {
-				return nil, err
-			}
-			req.Entity = append(req.Entity, sProto)
+		sProto, err := saveEntity(appID, key[i], sIface, noIndex)
+		if err != nil {
+			return nil, err
 		}
+		req.Entity = append(req.Entity, sProto)
 	}
 	res := &pb.PutResponse{}
 	err := c.Call("datastore_v3", "Put", req, res, nil)
@@ -364,14 +602,29 @@ func PutMulti(c appengine.Context, key []*Key, src []interface{}) ([]*Key, error
 	}
 	ret := make([]*Key, len(key))
 	for i := range ret {
-		ret[i], err = protoToKey(res.Key[i])
-		if err != nil || ret[i].Incomplete() {
+		k, err := protoToKey(res.Key[i])
+		if err != nil || k.Incomplete() || !allocatedFor(k, key[i]) {
 			return nil, errors.New("datastore: internal error: server returned an invalid key")
 		}
+		if key[i].Incomplete() {
+			ret[i] = k
+		} else {
+			ret[i] = key[i]
+		}
 	}
 	return ret, nil
 }
 
+// allocatedFor returns whether the key k, returned by a Put call, is the key
+// the entity put under the key src was stored with: src itself if it is
+// complete, or else a key with the same kind, parent and namespace.
+func allocatedFor(k, src *Key) bool {
+	if !src.Incomplete() {
+		return k.Eq(src)
+	}
+	return k.kind == src.kind && k.namespace == src.namespace && k.parent.Eq(src.parent)
+}
+
 // Delete deletes the entity for the given key.
 func Delete(c appengine.Context, key *Key) error {
 	err := DeleteMulti(c, []*Key{key})
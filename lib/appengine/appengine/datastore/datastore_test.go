@@ -0,0 +1,1343 @@
+// Copyright 2011 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package datastore
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"appengine"
+	"appengine_internal"
+	"goprotobuf.googlecode.com/hg/proto"
+
+	pb "appengine_internal/datastore"
+)
+
+const testAppID = "dev~app"
+
+var testKey = &Key{kind: "T", stringID: "a", appID: testAppID}
+
+// propertyNames returns the names of the indexed and raw properties of e.
+func propertyNames(e *pb.EntityProto) (indexed, raw []string) {
+	for _, p := range e.Property {
+		indexed = append(indexed, proto.GetString(p.Name))
+	}
+	for _, p := range e.RawProperty {
+		raw = append(raw, proto.GetString(p.Name))
+	}
+	return
+}
+
+type tagged struct {
+	CreatedAt int64  `datastore:"created"`
+	Title     string `datastore:",noindex"`
+	Ignored   string `datastore:"-"`
+	Plain     bool
+}
+
+func TestStructTagRoundTrip(t *testing.T) {
+	src := &tagged{CreatedAt: 42, Title: "t", Ignored: "x", Plain: true}
+	e, err := saveStruct(testAppID, testKey, reflect.ValueOf(src).Elem())
+	if err != nil {
+		t.Fatalf("saveStruct: %v", err)
+	}
+	indexed, raw := propertyNames(e)
+	if !reflect.DeepEqual(indexed, []string{"created", "Plain"}) {
+		t.Errorf("indexed properties: got %v", indexed)
+	}
+	if !reflect.DeepEqual(raw, []string{"Title"}) {
+		t.Errorf("raw properties: got %v", raw)
+	}
+
+	dst := new(tagged)
+	if err := loadStruct(reflect.ValueOf(dst).Elem(), testKey, e); err != nil {
+		t.Fatalf("loadStruct: %v", err)
+	}
+	want := &tagged{CreatedAt: 42, Title: "t", Plain: true}
+	if !reflect.DeepEqual(dst, want) {
+		t.Errorf("got %+v, want %+v", dst, want)
+	}
+}
+
+func TestStructTagLegacyName(t *testing.T) {
+	// An entity saved before CreatedAt was tagged uses the Go field name.
+	e := &pb.EntityProto{
+		Property: []*pb.Property{
+			&pb.Property{
+				Name:     proto.String("CreatedAt"),
+				Value:    &pb.PropertyValue{Int64Value: proto.Int64(7)},
+				Multiple: proto.Bool(false),
+			},
+		},
+	}
+	dst := new(tagged)
+	if err := loadStruct(reflect.ValueOf(dst).Elem(), testKey, e); err != nil {
+		t.Fatalf("loadStruct: %v", err)
+	}
+	if dst.CreatedAt != 7 {
+		t.Errorf("CreatedAt: got %d, want 7", dst.CreatedAt)
+	}
+}
+
+func TestStructTagErrors(t *testing.T) {
+	type repeated struct {
+		A int `datastore:"x"`
+		B int `datastore:"x"`
+	}
+	type badOption struct {
+		A int `datastore:"a,bogus"`
+	}
+	for _, v := range []interface{}{&repeated{}, &badOption{}} {
+		if _, err := saveStruct(testAppID, testKey, reflect.ValueOf(v).Elem()); err == nil {
+			t.Errorf("%T: expected an error", v)
+		}
+	}
+}
+
+func TestTooManyIndexedProperties(t *testing.T) {
+	for _, n := range []int{maxIndexedProperties, maxIndexedProperties + 1} {
+		m := Map{"Values": make([]int64, n)}
+		_, err := saveMap(testAppID, testKey, m)
+		if n <= maxIndexedProperties {
+			if err != nil {
+				t.Errorf("%d properties: unexpected error: %v", n, err)
+			}
+			continue
+		}
+		e, ok := err.(*ErrTooManyIndexedProperties)
+		if !ok {
+			t.Errorf("%d properties: expected ErrTooManyIndexedProperties, got %v", n, err)
+			continue
+		}
+		if e.Count != n {
+			t.Errorf("Count: got %d, want %d", e.Count, n)
+		}
+	}
+}
+
+func TestTooManyIndexedStructProperties(t *testing.T) {
+	type series struct {
+		Points  []int64
+		Samples []int64 `datastore:",noindex"`
+	}
+	c := &fakeContext{entities: make(map[string]*pb.EntityProto)}
+	k := NewKey(c, "Series", "s", 0, nil)
+
+	// Unindexed properties don't count.
+	src := &series{Points: make([]int64, 10), Samples: make([]int64, maxIndexedProperties+1)}
+	if _, err := Put(c, k, src); err != nil {
+		t.Errorf("noindex field: unexpected error: %v", err)
+	}
+
+	src = &series{Points: make([]int64, maxIndexedProperties+1)}
+	_, err := Put(c, k, src)
+	e, ok := err.(*ErrTooManyIndexedProperties)
+	if !ok || e.TypeName != "series" || e.Count != maxIndexedProperties+1 {
+		t.Fatalf("expected ErrTooManyIndexedProperties for 5001 series properties, got %v", err)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "5001") {
+		t.Errorf("error %q doesn't name the count", msg)
+	}
+	if len(c.methods) != 1 {
+		t.Errorf("expected only the first Put to reach the datastore, got %v", c.methods)
+	}
+
+	// Neither do properties saved unindexed by PutMultiWithOptions.
+	if _, err := PutMultiWithOptions(c, []*Key{k}, []interface{}{src}, &PutOptions{Unindexed: true}); err != nil {
+		t.Errorf("PutMultiWithOptions: unexpected error: %v", err)
+	}
+}
+
+func TestBlobLength(t *testing.T) {
+	type document struct {
+		Data []byte
+	}
+	if _, err := saveStruct(testAppID, testKey, reflect.ValueOf(document{make([]byte, maxBlobLen)})); err != nil {
+		t.Errorf("%d bytes: unexpected error: %v", maxBlobLen, err)
+	}
+	_, err := saveStruct(testAppID, testKey, reflect.ValueOf(document{make([]byte, maxBlobLen+1)}))
+	if err == nil || !strings.Contains(err.Error(), `"Data"`) {
+		t.Errorf("%d bytes: expected an error naming the field, got %v", maxBlobLen+1, err)
+	}
+	if _, err := saveMap(testAppID, testKey, Map{"Data": make([]byte, maxBlobLen+1)}); err == nil {
+		t.Errorf("%d bytes in a Map: expected an error", maxBlobLen+1)
+	}
+}
+
+func TestNamedTypes(t *testing.T) {
+	type (
+		Tags  []string
+		Count int
+		Raw   []byte
+	)
+	type named struct {
+		Tags  Tags
+		Count Count
+		Raw   Raw
+	}
+	type underlying struct {
+		Tags  []string
+		Count int
+		Raw   []byte
+	}
+	src := &named{Tags{"a", "b"}, 3, Raw("xyz")}
+	e, err := saveStruct(testAppID, testKey, reflect.ValueOf(src).Elem())
+	if err != nil {
+		t.Fatalf("saveStruct: %v", err)
+	}
+	want, err := saveStruct(testAppID, testKey, reflect.ValueOf(underlying{[]string{"a", "b"}, 3, []byte("xyz")}))
+	if err != nil {
+		t.Fatalf("saveStruct: %v", err)
+	}
+	if !reflect.DeepEqual(e, want) {
+		t.Errorf("saved named types as %v, want %v", e, want)
+	}
+
+	dst := new(named)
+	if err := loadStruct(reflect.ValueOf(dst).Elem(), testKey, e); err != nil {
+		t.Fatalf("loadStruct: %v", err)
+	}
+	if !reflect.DeepEqual(dst, src) {
+		t.Errorf("got %+v, want %+v", dst, src)
+	}
+}
+
+func TestSemanticTypes(t *testing.T) {
+	type contact struct {
+		Email    Email
+		Homepage Link
+		Group    Category
+		Stars    Rating
+		Links    []Link
+	}
+	src := &contact{"a@example.com", "http://example.com/", "friends", 80, []Link{"http://a/", "http://b/"}}
+	e, err := saveStruct(testAppID, testKey, reflect.ValueOf(src).Elem())
+	if err != nil {
+		t.Fatalf("saveStruct: %v", err)
+	}
+	want := map[string]pb.Property_Meaning{
+		"Email":    pb.Property_GD_EMAIL,
+		"Homepage": pb.Property_ATOM_LINK,
+		"Group":    pb.Property_ATOM_CATEGORY,
+		"Stars":    pb.Property_GD_RATING,
+		"Links":    pb.Property_ATOM_LINK,
+	}
+	for _, p := range e.Property {
+		if name := proto.GetString(p.Name); p.Meaning == nil || *p.Meaning != want[name] {
+			t.Errorf("%s: got meaning %v, want %v", name, p.Meaning, want[name])
+		}
+	}
+
+	dst := new(contact)
+	if err := loadStruct(reflect.ValueOf(dst).Elem(), testKey, e); err != nil {
+		t.Fatalf("loadStruct: %v", err)
+	}
+	if !reflect.DeepEqual(dst, src) {
+		t.Errorf("got %+v, want %+v", dst, src)
+	}
+
+	m := make(Map)
+	if err := loadMap(m, testKey, e); err != nil {
+		t.Fatalf("loadMap: %v", err)
+	}
+	// Maps get the plain types, as for entities saved before the semantic
+	// types existed.
+	wantMap := Map{
+		"Email":    string(src.Email),
+		"Homepage": string(src.Homepage),
+		"Group":    string(src.Group),
+		"Stars":    int64(src.Stars),
+		"Links":    []string{string(src.Links[0]), string(src.Links[1])},
+	}
+	if !reflect.DeepEqual(m, wantMap) {
+		t.Errorf("got %v, want %v", m, wantMap)
+	}
+
+	if _, err := saveStruct(testAppID, testKey, reflect.ValueOf(contact{Stars: 101})); err == nil {
+		t.Errorf("expected an error for a rating above 100")
+	}
+}
+
+func TestTimeSlices(t *testing.T) {
+	type visits struct {
+		At []Time
+	}
+	times := []*time.Time{time.SecondsToUTC(1e9), time.SecondsToUTC(1.3e9)}
+	src := &visits{At: TimesFromTimes(times)}
+	if src.At[0] != SecondsToTime(1e9) || src.At[1] != SecondsToTime(1.3e9) {
+		t.Errorf("TimesFromTimes: got %v", src.At)
+	}
+	e, err := saveStruct(testAppID, testKey, reflect.ValueOf(src).Elem())
+	if err != nil {
+		t.Fatalf("saveStruct: %v", err)
+	}
+	for _, p := range e.Property {
+		if p.Meaning == nil || *p.Meaning != pb.Property_GD_WHEN {
+			t.Errorf("property %q: got meaning %v, want GD_WHEN", proto.GetString(p.Name), p.Meaning)
+		}
+	}
+	dst := new(visits)
+	if err := loadStruct(reflect.ValueOf(dst).Elem(), testKey, e); err != nil {
+		t.Fatalf("loadStruct: %v", err)
+	}
+	if !reflect.DeepEqual(dst.At, src.At) {
+		t.Errorf("At: got %v, want %v", dst.At, src.At)
+	}
+	got := TimesToTimes(dst.At)
+	if len(got) != len(times) {
+		t.Fatalf("TimesToTimes: got %d times, want %d", len(got), len(times))
+	}
+	for i := range got {
+		if got[i].Seconds() != times[i].Seconds() {
+			t.Errorf("TimesToTimes %d: got %v, want %v", i, got[i], times[i])
+		}
+	}
+}
+
+func TestFloatValues(t *testing.T) {
+	type measure struct {
+		Value float64
+	}
+	testCases := []struct {
+		value float64
+		ok    bool
+	}{
+		{1.5, true},
+		{math.NaN(), false},
+		{math.Inf(1), false},
+		{math.Inf(-1), false},
+	}
+	for _, tc := range testCases {
+		_, err := saveStruct(testAppID, testKey, reflect.ValueOf(measure{tc.value}))
+		if tc.ok {
+			if err != nil {
+				t.Errorf("%v: unexpected error: %v", tc.value, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), `"Value"`) {
+			t.Errorf("%v: expected an error naming the field, got %v", tc.value, err)
+		}
+	}
+}
+
+type address struct {
+	City string
+	Zip  string `datastore:"zip"`
+}
+
+type person struct {
+	Name string
+	Home *address
+	Work *address `datastore:"office"`
+}
+
+func TestNestedStructPointer(t *testing.T) {
+	src := &person{Name: "n", Work: &address{City: "c", Zip: "z"}}
+	e, err := saveStruct(testAppID, testKey, reflect.ValueOf(src).Elem())
+	if err != nil {
+		t.Fatalf("saveStruct: %v", err)
+	}
+	indexed, _ := propertyNames(e)
+	if !reflect.DeepEqual(indexed, []string{"Name", "office.City", "office.zip"}) {
+		t.Errorf("indexed properties: got %v", indexed)
+	}
+
+	dst := new(person)
+	if err := loadStruct(reflect.ValueOf(dst).Elem(), testKey, e); err != nil {
+		t.Fatalf("loadStruct: %v", err)
+	}
+	if dst.Home != nil {
+		t.Errorf("Home: got %+v, want nil", dst.Home)
+	}
+	if dst.Work == nil || *dst.Work != *src.Work {
+		t.Errorf("Work: got %+v, want %+v", dst.Work, src.Work)
+	}
+
+	// Loading an entity without nested properties leaves both pointers nil.
+	e, err = saveStruct(testAppID, testKey, reflect.ValueOf(&person{Name: "n"}).Elem())
+	if err != nil {
+		t.Fatalf("saveStruct: %v", err)
+	}
+	dst = new(person)
+	if err := loadStruct(reflect.ValueOf(dst).Elem(), testKey, e); err != nil {
+		t.Fatalf("loadStruct: %v", err)
+	}
+	if dst.Home != nil || dst.Work != nil {
+		t.Errorf("got Home=%+v Work=%+v, want both nil", dst.Home, dst.Work)
+	}
+}
+
+type node struct {
+	Name string
+	Next *node
+}
+
+func TestCyclicStruct(t *testing.T) {
+	n := &node{Name: "a"}
+	n.Next = n
+	_, err := saveStruct(testAppID, testKey, reflect.ValueOf(n).Elem())
+	if err == nil || !strings.HasPrefix(err.Error(), "datastore: ") {
+		t.Errorf("self reference: got %v, want a datastore error", err)
+	}
+	n.Next = &node{Name: "b", Next: n}
+	if _, err := saveStruct(testAppID, testKey, reflect.ValueOf(n).Elem()); err == nil {
+		t.Errorf("indirect cycle: expected an error")
+	}
+
+	// The same pointer in sibling fields isn't a cycle.
+	shared := &address{City: "c"}
+	if _, err := saveStruct(testAppID, testKey, reflect.ValueOf(&person{Home: shared, Work: shared}).Elem()); err != nil {
+		t.Errorf("shared pointer: %v", err)
+	}
+}
+
+func TestMapField(t *testing.T) {
+	type product struct {
+		Name  string
+		Attrs map[string]interface{}
+		Extra Map `datastore:"extra,noindex"`
+	}
+	k := NewKey(&fakeContext{}, "Widget", "a", 0, nil)
+	src := &product{
+		Name: "p",
+		Attrs: map[string]interface{}{
+			"color":  "red",
+			"weight": int64(3),
+			"price":  9.5,
+			"sale":   true,
+			"sizes":  []int64{1, 2},
+			"maker":  k,
+			"nil":    nil,
+		},
+		Extra: Map{"note": "n"},
+	}
+	e, err := saveStruct(testAppID, testKey, reflect.ValueOf(src).Elem())
+	if err != nil {
+		t.Fatalf("saveStruct: %v", err)
+	}
+	indexed, unindexed := propertyNames(e)
+	sort.Strings(indexed)
+	want := []string{"Attrs.color", "Attrs.maker", "Attrs.price", "Attrs.sale",
+		"Attrs.sizes", "Attrs.sizes", "Attrs.weight", "Name"}
+	if !reflect.DeepEqual(indexed, want) {
+		t.Errorf("indexed properties: got %v, want %v", indexed, want)
+	}
+	if !reflect.DeepEqual(unindexed, []string{"extra.note"}) {
+		t.Errorf("unindexed properties: got %v", unindexed)
+	}
+
+	dst := new(product)
+	if err := loadStruct(reflect.ValueOf(dst).Elem(), testKey, e); err != nil {
+		t.Fatalf("loadStruct: %v", err)
+	}
+	delete(src.Attrs, "nil")
+	if len(dst.Attrs) != len(src.Attrs) {
+		t.Errorf("Attrs: got %v, want %v", dst.Attrs, src.Attrs)
+	}
+	for name, v := range src.Attrs {
+		if name == "maker" {
+			if key, ok := dst.Attrs[name].(*Key); !ok || !key.Eq(k) {
+				t.Errorf("Attrs[%q]: got %v, want %v", name, dst.Attrs[name], v)
+			}
+		} else if !reflect.DeepEqual(dst.Attrs[name], v) {
+			t.Errorf("Attrs[%q]: got %#v, want %#v", name, dst.Attrs[name], v)
+		}
+	}
+	if !reflect.DeepEqual(dst.Extra, src.Extra) {
+		t.Errorf("Extra: got %v, want %v", dst.Extra, src.Extra)
+	}
+}
+
+// fakeContext is an appengine.Context that serves datastore Get, Put and
+// Delete calls and queries with only equality filters from a map of entities
+// keyed by encoded key. Put allocates increasing IDs to incomplete keys.
+// Transactions always commit. It records the methods called, the queries it
+// runs and the size of each Delete call.
+type fakeContext struct {
+	entities map[string]*pb.EntityProto
+	lastID   int64
+	methods  []string
+	queries  []*pb.Query
+	deletes  []int
+	// pageSize, if positive, is the number of query results returned by
+	// each RunQuery or Next call; pending holds those not yet returned.
+	pageSize int
+	pending  []*pb.EntityProto
+}
+
+func (c *fakeContext) Debugf(format string, args ...interface{})    {}
+func (c *fakeContext) Infof(format string, args ...interface{})     {}
+func (c *fakeContext) Warningf(format string, args ...interface{})  {}
+func (c *fakeContext) Errorf(format string, args ...interface{})    {}
+func (c *fakeContext) Criticalf(format string, args ...interface{}) {}
+func (c *fakeContext) AppID() string                                { return "app" }
+func (c *fakeContext) FullyQualifiedAppID() string                  { return testAppID }
+func (c *fakeContext) Request() interface{}                         { return nil }
+
+func (c *fakeContext) Call(service, method string, in, out interface{}, opts *appengine_internal.CallOptions) error {
+	c.methods = append(c.methods, method)
+	switch method {
+	case "BeginTransaction", "Commit", "Rollback":
+		return nil
+	}
+	if q, ok := in.(*pb.Query); ok {
+		c.queries = append(c.queries, q)
+		res := out.(*pb.QueryResult)
+		c.pending = c.query(q)
+		c.nextPage(res)
+		res.KeysOnly = q.KeysOnly
+		return nil
+	}
+	if _, ok := in.(*pb.NextRequest); ok {
+		c.nextPage(out.(*pb.QueryResult))
+		return nil
+	}
+	if req, ok := in.(*pb.PutRequest); ok {
+		res := out.(*pb.PutResponse)
+		for _, e := range req.Entity {
+			path := e.Key.Path.Element
+			if last := path[len(path)-1]; last.Id == nil && last.Name == nil {
+				c.lastID++
+				last.Id = proto.Int64(c.lastID)
+			}
+			k, err := protoToKey(e.Key)
+			if err != nil {
+				return err
+			}
+			c.entities[k.Encode()] = e
+			res.Key = append(res.Key, e.Key)
+		}
+		return nil
+	}
+	if req, ok := in.(*pb.DeleteRequest); ok {
+		c.deletes = append(c.deletes, len(req.Key))
+		for _, r := range req.Key {
+			k, err := protoToKey(r)
+			if err != nil {
+				return err
+			}
+			delete(c.entities, k.Encode())
+		}
+		return nil
+	}
+	req, res := in.(*pb.GetRequest), out.(*pb.GetResponse)
+	for _, r := range req.Key {
+		k, err := protoToKey(r)
+		if err != nil {
+			return err
+		}
+		res.Entity = append(res.Entity, &pb.GetResponse_Entity{Entity: c.entities[k.Encode()]})
+	}
+	return nil
+}
+
+// nextPage moves the next page of pending query results into res.
+func (c *fakeContext) nextPage(res *pb.QueryResult) {
+	n := len(c.pending)
+	if c.pageSize > 0 && c.pageSize < n {
+		n = c.pageSize
+	}
+	res.Result, c.pending = c.pending[:n], c.pending[n:]
+	res.MoreResults = proto.Bool(len(c.pending) > 0)
+	if len(c.pending) > 0 {
+		res.Cursor = &pb.Cursor{Cursor: proto.Uint64(1)}
+	}
+}
+
+// query returns the entities matching q, ordered by encoded key.
+func (c *fakeContext) query(q *pb.Query) []*pb.EntityProto {
+	var encoded []string
+	for k := range c.entities {
+		encoded = append(encoded, k)
+	}
+	sort.Strings(encoded)
+	var result []*pb.EntityProto
+	for _, k := range encoded {
+		e := c.entities[k]
+		path := e.Key.Path.Element
+		if proto.GetString(path[len(path)-1].Type) != proto.GetString(q.Kind) ||
+			proto.GetString(e.Key.NameSpace) != proto.GetString(q.NameSpace) {
+			continue
+		}
+		match := true
+		for _, f := range q.Filter {
+			match = match && hasProperty(e, f.Property[0])
+		}
+		if match {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// hasProperty returns whether e has a property with the name and value of p.
+func hasProperty(e *pb.EntityProto, p *pb.Property) bool {
+	for _, ep := range e.Property {
+		if proto.GetString(ep.Name) == proto.GetString(p.Name) && ep.Value.String() == p.Value.String() {
+			return true
+		}
+	}
+	return false
+}
+
+type widget struct {
+	Name  string
+	Price int64
+}
+
+func TestGetAllByKey(t *testing.T) {
+	c := &fakeContext{entities: make(map[string]*pb.EntityProto)}
+	for _, w := range []widget{{"a", 1}, {"b", 2}} {
+		k := &Key{kind: "Widget", stringID: w.Name, appID: testAppID}
+		e, err := saveStruct(testAppID, k, reflect.ValueOf(w))
+		if err != nil {
+			t.Fatalf("saveStruct: %v", err)
+		}
+		c.entities[k.Encode()] = e
+	}
+	keys := func(names ...string) []*Key {
+		var ks []*Key
+		for _, name := range names {
+			ks = append(ks, &Key{kind: "Widget", stringID: name, appID: testAppID})
+		}
+		return ks
+	}
+
+	var ptrs []*widget
+	if err := GetAllByKey(c, keys("b", "a"), &ptrs); err != nil {
+		t.Fatalf("GetAllByKey: %v", err)
+	}
+	if len(ptrs) != 2 || *ptrs[0] != (widget{"b", 2}) || *ptrs[1] != (widget{"a", 1}) {
+		t.Errorf("[]*widget: got %v", ptrs)
+	}
+
+	var maps []Map
+	err := GetAllByKey(c, keys("a", "missing", "b"), &maps)
+	errMulti, ok := err.(ErrMulti)
+	if !ok || errMulti[0] != nil || errMulti[1] != ErrNoSuchEntity || errMulti[2] != nil {
+		t.Fatalf("[]Map: expected ErrNoSuchEntity at index 1, got %v", err)
+	}
+	if len(maps) != 3 || maps[0]["Name"] != "a" || len(maps[1]) != 0 || maps[2]["Price"] != int64(2) {
+		t.Errorf("[]Map: got %v", maps)
+	}
+
+	if err := GetAllByKey(c, keys("a"), &[]int{}); err != ErrInvalidEntityType {
+		t.Errorf("[]int: expected ErrInvalidEntityType, got %v", err)
+	}
+}
+
+func TestPutMultiKeyOrder(t *testing.T) {
+	c := &fakeContext{entities: make(map[string]*pb.EntityProto)}
+	parent := NewKey(c, "Shop", "s", 0, nil)
+	keys := []*Key{
+		NewIncompleteKey(c, "Widget", nil),
+		NewKey(c, "Widget", "b", 0, nil),
+		NewIncompleteKey(c, "Widget", parent),
+		NewKey(c, "Widget", "", 7, parent),
+		NewIncompleteKey(c, "Widget", nil),
+	}
+	src := make([]interface{}, len(keys))
+	for i := range src {
+		src[i] = &widget{fmt.Sprint(i), int64(i)}
+	}
+	got, err := PutMulti(c, keys, src)
+	if err != nil {
+		t.Fatalf("PutMulti: %v", err)
+	}
+	want := []*Key{
+		NewKey(c, "Widget", "", 1, nil),
+		keys[1],
+		NewKey(c, "Widget", "", 2, parent),
+		keys[3],
+		NewKey(c, "Widget", "", 3, nil),
+	}
+	for i := range want {
+		if !got[i].Eq(want[i]) {
+			t.Errorf("key %d: got %v, want %v", i, got[i], want[i])
+		}
+		var w widget
+		if err := Get(c, got[i], &w); err != nil || w.Price != int64(i) {
+			t.Errorf("key %d: got entity %v, %v", i, w, err)
+		}
+	}
+	if got[1] != keys[1] || got[3] != keys[3] {
+		t.Errorf("complete keys were not returned as is")
+	}
+}
+
+func TestGetIgnoreUnknownFields(t *testing.T) {
+	c := &fakeContext{entities: make(map[string]*pb.EntityProto)}
+	key := NewKey(c, "Widget", "a", 0, nil)
+	if _, err := Put(c, key, Map{"Name": "a", "Price": int64(3), "Color": "red"}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	var w widget
+	if err := Get(c, key, &w); err == nil {
+		t.Errorf("Get: expected ErrFieldMismatch, got nil")
+	} else if e, ok := err.(*ErrFieldMismatch); !ok || e.FieldName != "Color" {
+		t.Errorf("Get: expected ErrFieldMismatch for Color, got %v", err)
+	}
+
+	w = widget{}
+	opts := &GetOptions{IgnoreUnknownFields: true}
+	if err := GetWithOptions(c, key, &w, opts); err != nil {
+		t.Errorf("GetWithOptions: %v", err)
+	}
+	if w.Name != "a" || w.Price != 3 {
+		t.Errorf("GetWithOptions: got %v", w)
+	}
+
+	// Type mismatches are still reported.
+	if _, err := Put(c, key, Map{"Name": int64(1), "Color": "red"}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := GetWithOptions(c, key, &w, opts); err == nil {
+		t.Errorf("GetWithOptions: expected ErrFieldMismatch, got nil")
+	} else if e, ok := err.(*ErrFieldMismatch); !ok || e.FieldName != "Name" {
+		t.Errorf("GetWithOptions: expected ErrFieldMismatch for Name, got %v", err)
+	}
+}
+
+func TestPutMultiInvalidSrc(t *testing.T) {
+	c := &fakeContext{entities: make(map[string]*pb.EntityProto)}
+	keys := []*Key{
+		NewKey(c, "Widget", "a", 0, nil),
+		NewKey(c, "Widget", "b", 0, nil),
+		NewIncompleteKey(c, "Widget", nil),
+		NewKey(c, "Widget", "d", 0, nil),
+	}
+	src := []interface{}{
+		&widget{"a", 1},
+		widget{"b", 2},
+		Map{"Name": "c"},
+		&widget{"d", 4},
+	}
+	_, err := PutMulti(c, keys, src)
+	errMulti, ok := err.(ErrMulti)
+	if !ok || len(errMulti) != len(keys) {
+		t.Fatalf("expected an ErrMulti of length %d, got %v", len(keys), err)
+	}
+	for i, e := range errMulti {
+		if i == 1 && e != ErrInvalidEntityType {
+			t.Errorf("element 1: expected ErrInvalidEntityType, got %v", e)
+		} else if i != 1 && e != nil {
+			t.Errorf("element %d: expected no error, got %v", i, e)
+		}
+	}
+	if len(c.methods) != 0 {
+		t.Errorf("expected no RPC, got %v", c.methods)
+	}
+
+	if _, err := Put(c, keys[0], 42); err != ErrInvalidEntityType {
+		t.Errorf("Put: expected ErrInvalidEntityType, got %v", err)
+	}
+}
+
+func TestMapAccessors(t *testing.T) {
+	c := &fakeContext{}
+	k := NewKey(c, "Widget", "a", 0, nil)
+	m := Map{
+		"Int":    int64(7),
+		"Bool":   true,
+		"String": "s",
+		"Float":  1.5,
+		"Bytes":  []byte("b"),
+		"Key":    k,
+		"Time":   Time(42),
+		"Blob":   appengine.BlobKey("bk"),
+		"Email":  Email("a@b.c"),
+	}
+	if v, ok := m.Int64("Int"); !ok || v != 7 {
+		t.Errorf("Int64: got %v, %v", v, ok)
+	}
+	if v, ok := m.Bool("Bool"); !ok || !v {
+		t.Errorf("Bool: got %v, %v", v, ok)
+	}
+	if v, ok := m.String("String"); !ok || v != "s" {
+		t.Errorf("String: got %q, %v", v, ok)
+	}
+	if v, ok := m.Float64("Float"); !ok || v != 1.5 {
+		t.Errorf("Float64: got %v, %v", v, ok)
+	}
+	if v, ok := m.Bytes("Bytes"); !ok || string(v) != "b" {
+		t.Errorf("Bytes: got %q, %v", v, ok)
+	}
+	if v, ok := m.Key("Key"); !ok || v != k {
+		t.Errorf("Key: got %v, %v", v, ok)
+	}
+	if v, ok := m.Time("Time"); !ok || v != 42 {
+		t.Errorf("Time: got %v, %v", v, ok)
+	}
+	if v, ok := m.BlobKey("Blob"); !ok || v != "bk" {
+		t.Errorf("BlobKey: got %q, %v", v, ok)
+	}
+
+	// Absent entries.
+	if v, ok := m.Int64("Missing"); ok || v != 0 {
+		t.Errorf("Int64 of a missing entry: got %v, %v", v, ok)
+	}
+	if v, ok := m.Key("Missing"); ok || v != nil {
+		t.Errorf("Key of a missing entry: got %v, %v", v, ok)
+	}
+
+	// Entries of another type.
+	if v, ok := m.Int64("String"); ok || v != 0 {
+		t.Errorf("Int64 of a string: got %v, %v", v, ok)
+	}
+	if v, ok := m.String("Email"); ok || v != "" {
+		t.Errorf("String of an Email: got %q, %v", v, ok)
+	}
+	if v, ok := m.Time("Int"); ok || v != 0 {
+		t.Errorf("Time of an int64: got %v, %v", v, ok)
+	}
+}
+
+func TestKeyNamespace(t *testing.T) {
+	c := &fakeContext{entities: make(map[string]*pb.EntityProto)}
+	nc := inNamespace(t, c, "tenant")
+	k0 := NewKey(c, "T", "a", 0, nil)
+	k1 := NewKey(nc, "T", "a", 0, nil)
+	if k0.Namespace() != "" || k1.Namespace() != "tenant" {
+		t.Fatalf("namespaces: got %q and %q", k0.Namespace(), k1.Namespace())
+	}
+	if k0.Eq(k1) || k0.Encode() == k1.Encode() {
+		t.Errorf("keys in distinct namespaces are equal")
+	}
+	if ref := keyToProto(testAppID, k0); ref.NameSpace != nil {
+		t.Errorf("default namespace: got name_space %q", proto.GetString(ref.NameSpace))
+	}
+	k2, err := DecodeKey(k1.Encode())
+	if err != nil || !k2.Eq(k1) {
+		t.Errorf("DecodeKey: got %v, %v", k2, err)
+	}
+	// A child must be in its parent's namespace.
+	if NewKey(c, "T", "b", 0, k1).valid() {
+		t.Errorf("expected a key with a parent in another namespace to be invalid")
+	}
+
+	e, err := saveStruct(testAppID, k1, reflect.ValueOf(widget{"a", 1}))
+	if err != nil {
+		t.Fatalf("saveStruct: %v", err)
+	}
+	c.entities[k1.Encode()] = e
+	if err := Get(c, k0, new(widget)); err != ErrNoSuchEntity {
+		t.Errorf("default namespace: expected ErrNoSuchEntity, got %v", err)
+	}
+	var w widget
+	if err := Get(nc, k1, &w); err != nil || w.Name != "a" {
+		t.Errorf("tenant namespace: got %v, %v", w, err)
+	}
+
+	var q pb.Query
+	if err := NewQuery("T").toProto(&q, testAppID, "tenant", zeroLimitMeansUnlimited); err != nil {
+		t.Fatalf("toProto: %v", err)
+	}
+	if proto.GetString(q.NameSpace) != "tenant" {
+		t.Errorf("query: got name_space %q", proto.GetString(q.NameSpace))
+	}
+}
+
+func TestAppengineNamespace(t *testing.T) {
+	c := &fakeContext{entities: make(map[string]*pb.EntityProto)}
+	nc, err := appengine.Namespace(c, "ns")
+	if err != nil {
+		t.Fatalf("appengine.Namespace: %v", err)
+	}
+	k0 := NewKey(c, "T", "a", 0, nil)
+	k1 := NewKey(nc, "T", "a", 0, nil)
+	if k1.Namespace() != "ns" {
+		t.Errorf("namespace: got %q, want %q", k1.Namespace(), "ns")
+	}
+	if k0.Eq(k1) {
+		t.Errorf("keys in distinct namespaces are equal")
+	}
+	if got := NewKey(&transaction{Context: nc}, "T", "a", 0, nil); !got.Eq(k1) {
+		t.Errorf("transaction key: got %v, want %v", got, k1)
+	}
+}
+
+// inNamespace returns c scoped to the given namespace.
+func inNamespace(t *testing.T, c appengine.Context, namespace string) appengine.Context {
+	nc, err := appengine.Namespace(c, namespace)
+	if err != nil {
+		t.Fatalf("appengine.Namespace: %v", err)
+	}
+	return nc
+}
+
+// putWidgets stores widgets in c under keys named after them, in the given
+// namespace.
+func putWidgets(t *testing.T, c *fakeContext, namespace string, widgets ...widget) {
+	for _, w := range widgets {
+		k := NewKey(inNamespace(t, c, namespace), "Widget", w.Name, 0, nil)
+		e, err := saveStruct(testAppID, k, reflect.ValueOf(w))
+		if err != nil {
+			t.Fatalf("saveStruct: %v", err)
+		}
+		c.entities[k.Encode()] = e
+	}
+}
+
+func TestQueryOr(t *testing.T) {
+	c := &fakeContext{entities: make(map[string]*pb.EntityProto)}
+	putWidgets(t, c, "", widget{"a", 1}, widget{"b", 2}, widget{"c", 2}, widget{"d", 3})
+
+	// "c" matches both queries but is returned once.
+	q := NewQuery("Widget").Filter("Price =", int64(2)).
+		Or(NewQuery("Widget").Filter("Name =", "a"), NewQuery("Widget").Filter("Name =", "c"))
+	var got []widget
+	keys, err := q.GetAll(c, &got)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	want := []widget{{"b", 2}, {"c", 2}, {"a", 1}}
+	if !reflect.DeepEqual(got, want) || len(keys) != len(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if len(c.queries) != 3 {
+		t.Errorf("got %d queries, want 3", len(c.queries))
+	}
+	if n, err := q.Count(c); err != nil || n != 3 {
+		t.Errorf("Count: got %d, %v, want 3", n, err)
+	}
+
+	q = NewQuery("Widget").KeysOnly().Or(NewQuery("Widget"))
+	if _, err := q.GetAll(c, nil); err == nil {
+		t.Errorf("expected an error mixing keys-only and non keys-only queries")
+	}
+}
+
+func TestQueryNamespace(t *testing.T) {
+	c := &fakeContext{entities: make(map[string]*pb.EntityProto)}
+	putWidgets(t, c, "", widget{"a", 1})
+	putWidgets(t, c, "tenant", widget{"b", 2})
+
+	names := func(q *Query, c appengine.Context) []string {
+		var ws []widget
+		if _, err := q.GetAll(c, &ws); err != nil {
+			t.Fatalf("GetAll: %v", err)
+		}
+		var names []string
+		for _, w := range ws {
+			names = append(names, w.Name)
+		}
+		return names
+	}
+	if got := names(NewQuery("Widget").Namespace("tenant"), c); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("tenant namespace: got %v", got)
+	}
+	if got := names(NewQuery("Widget"), c); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("default namespace: got %v", got)
+	}
+	// The query's namespace overrides the context's, even if empty.
+	if got := names(NewQuery("Widget").Namespace(""), inNamespace(t, c, "tenant")); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("default namespace: got %v", got)
+	}
+	if ns := proto.GetString(c.queries[0].NameSpace); ns != "tenant" {
+		t.Errorf("query: got name_space %q, want tenant", ns)
+	}
+	if c.queries[2].NameSpace != nil {
+		t.Errorf("query: got name_space %q, want none", proto.GetString(c.queries[2].NameSpace))
+	}
+}
+
+func TestFilterRepeatedProperty(t *testing.T) {
+	type labeled struct {
+		Tag []string
+	}
+	c := &fakeContext{entities: make(map[string]*pb.EntityProto)}
+	for name, tags := range map[string][]string{"ab": {"a", "b"}, "a": {"a"}, "bc": {"b", "c"}} {
+		k := NewKey(c, "Tagged", name, 0, nil)
+		e, err := saveStruct(testAppID, k, reflect.ValueOf(labeled{tags}))
+		if err != nil {
+			t.Fatalf("saveStruct: %v", err)
+		}
+		c.entities[k.Encode()] = e
+	}
+
+	q := NewQuery("Tagged").Filter("Tag =", "a").Filter("Tag =", "b")
+	var pq pb.Query
+	if err := q.toProto(&pq, testAppID, "", zeroLimitMeansUnlimited); err != nil {
+		t.Fatalf("toProto: %v", err)
+	}
+	if len(pq.Filter) != 2 {
+		t.Fatalf("got %d filters, want 2", len(pq.Filter))
+	}
+	for i, want := range []string{"a", "b"} {
+		f := pq.Filter[i]
+		if *f.Op != pb.Query_Filter_EQUAL || proto.GetString(f.Property[0].Name) != "Tag" ||
+			proto.GetString(f.Property[0].Value.StringValue) != want {
+			t.Errorf("filter %d: got %v", i, f)
+		}
+	}
+
+	keys, err := q.KeysOnly().GetAll(c, nil)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(keys) != 1 || keys[0].StringID() != "ab" {
+		t.Errorf("got keys %v, want only ab", keys)
+	}
+
+	if _, err := NewQuery("Tagged").Filter("Tag =", []string{"a", "b"}).KeysOnly().GetAll(c, nil); err == nil {
+		t.Errorf("expected an error for a slice filter value")
+	}
+}
+
+func TestKeyFilterAndOrder(t *testing.T) {
+	c := &fakeContext{}
+	last := NewKey(c, "Post", "", 42, nil)
+	q := NewQuery("Post").Filter("__key__ >", last).Order("__key__").Order("-__key__")
+	var pq pb.Query
+	if err := q.toProto(&pq, testAppID, "", zeroLimitMeansUnlimited); err != nil {
+		t.Fatalf("toProto: %v", err)
+	}
+	if len(pq.Filter) != 1 {
+		t.Fatalf("got %d filters, want 1", len(pq.Filter))
+	}
+	f := pq.Filter[0]
+	if *f.Op != pb.Query_Filter_GREATER_THAN || proto.GetString(f.Property[0].Name) != "__key__" {
+		t.Errorf("got filter %v", f)
+	}
+	if k, err := referenceValueToKey(f.Property[0].Value.Referencevalue); err != nil || !k.Eq(last) {
+		t.Errorf("got filter key %v, %v, want %v", k, err, last)
+	}
+	if len(pq.Order) != 2 || proto.GetString(pq.Order[0].Property) != "__key__" ||
+		*pq.Order[0].Direction != pb.Query_Order_ASCENDING || *pq.Order[1].Direction != pb.Query_Order_DESCENDING {
+		t.Errorf("got orders %v", pq.Order)
+	}
+
+	for _, v := range []interface{}{"42", (*Key)(nil), NewIncompleteKey(c, "Post", nil)} {
+		if _, err := NewQuery("Post").Filter("__key__ >", v).Count(c); err == nil {
+			t.Errorf("%#v: expected an error for a __key__ filter value", v)
+		}
+	}
+}
+
+func TestEventualConsistency(t *testing.T) {
+	c := &fakeContext{}
+	parent := NewKey(c, "Blog", "golang", 0, nil)
+	for _, eventual := range []bool{true, false} {
+		var pq pb.Query
+		q := NewQuery("Post").Ancestor(parent).EventualConsistency(eventual)
+		if err := q.toProto(&pq, testAppID, "", zeroLimitMeansUnlimited); err != nil {
+			t.Fatalf("toProto: %v", err)
+		}
+		if pq.Strong == nil || *pq.Strong == eventual {
+			t.Errorf("eventual %v: got strong %v", eventual, pq.Strong)
+		}
+	}
+
+	var pq pb.Query
+	if err := NewQuery("Post").Ancestor(parent).toProto(&pq, testAppID, "", zeroLimitMeansUnlimited); err != nil {
+		t.Fatalf("toProto: %v", err)
+	}
+	if pq.Strong != nil {
+		t.Errorf("default: got strong %v, want it unset", *pq.Strong)
+	}
+}
+
+func TestFlattenTag(t *testing.T) {
+	type post struct {
+		Title string
+		Tags  []string `datastore:"Tags,flatten"`
+	}
+	c := &fakeContext{entities: make(map[string]*pb.EntityProto)}
+	src := &post{"Go", []string{"go", "appengine"}}
+	k, err := Put(c, NewKey(c, "Post", "go", 0, nil), src)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	e := c.entities[k.Encode()]
+	if n := len(e.Property); n != 3 || !proto.GetBool(e.Property[1].Multiple) {
+		t.Errorf("got %d properties %v, want Title and two multiple Tags values", n, e.Property)
+	}
+	dst := new(post)
+	if err := Get(c, k, dst); err != nil || !reflect.DeepEqual(dst, src) {
+		t.Errorf("Get: got %+v, %v, want %+v", dst, err, src)
+	}
+	keys, err := NewQuery("Post").Filter("Tags =", "appengine").KeysOnly().GetAll(c, nil)
+	if err != nil || len(keys) != 1 || !keys[0].Eq(k) {
+		t.Errorf("query by element: got %v, %v", keys, err)
+	}
+
+	type bad struct {
+		Title string `datastore:",flatten"`
+	}
+	if _, err := saveStruct(testAppID, testKey, reflect.ValueOf(bad{})); err == nil {
+		t.Errorf("expected an error for flatten on a non-slice field")
+	}
+}
+
+func TestQueryKeys(t *testing.T) {
+	c := &fakeContext{entities: make(map[string]*pb.EntityProto)}
+	for _, w := range []widget{{"a", 1}, {"b", 2}, {"c", 1}} {
+		k := NewKey(c, "Widget", w.Name, 0, nil)
+		e, err := saveStruct(testAppID, k, reflect.ValueOf(w))
+		if err != nil {
+			t.Fatalf("saveStruct: %v", err)
+		}
+		c.entities[k.Encode()] = e
+	}
+	want, err := NewQuery("Widget").Filter("Price =", 1).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	for _, q := range []*Query{
+		NewQuery("Widget").Filter("Price =", 1),
+		NewQuery("Widget").Filter("Price =", 1).KeysOnly(),
+	} {
+		got, err := q.Keys(c)
+		if err != nil {
+			t.Fatalf("Keys: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) || len(got) != 2 {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+
+	if _, err := NewQuery("Widget").Filter("Price", 1).Keys(c); err == nil {
+		t.Errorf("expected an error for a malformed query")
+	}
+}
+
+func TestDeleteAll(t *testing.T) {
+	c := &fakeContext{entities: make(map[string]*pb.EntityProto)}
+	for i := 0; i < 1203; i++ {
+		putWidgets(t, c, "", widget{fmt.Sprintf("w%d", i), 1})
+	}
+	putWidgets(t, c, "", widget{"keep", 2})
+
+	n, err := NewQuery("Widget").Filter("Price =", int64(1)).DeleteAll(c)
+	if err != nil {
+		t.Fatalf("DeleteAll: %v", err)
+	}
+	if n != 1203 {
+		t.Errorf("got %d deleted, want 1203", n)
+	}
+	if !reflect.DeepEqual(c.deletes, []int{500, 500, 203}) {
+		t.Errorf("got Delete batches %v, want [500 500 203]", c.deletes)
+	}
+	if len(c.entities) != 1 {
+		t.Errorf("got %d entities left, want 1", len(c.entities))
+	}
+	if !proto.GetBool(c.queries[0].KeysOnly) {
+		t.Errorf("expected a keys-only query")
+	}
+}
+
+func TestPutUnindexed(t *testing.T) {
+	c := &fakeContext{entities: make(map[string]*pb.EntityProto)}
+	k := NewKey(c, "Widget", "a", 0, nil)
+	for _, unindexed := range []bool{false, true} {
+		src := []interface{}{&widget{"a", 1}}
+		if _, err := PutMultiWithOptions(c, []*Key{k}, src, &PutOptions{Unindexed: unindexed}); err != nil {
+			t.Fatalf("PutMultiWithOptions: %v", err)
+		}
+		indexed, raw := propertyNames(c.entities[k.Encode()])
+		wantIndexed, wantRaw := []string{"Name", "Price"}, []string(nil)
+		if unindexed {
+			wantIndexed, wantRaw = wantRaw, wantIndexed
+		}
+		if !reflect.DeepEqual(indexed, wantIndexed) || !reflect.DeepEqual(raw, wantRaw) {
+			t.Errorf("Unindexed %v: got indexed %v, raw %v", unindexed, indexed, raw)
+		}
+	}
+}
+
+func TestGetOrInsert(t *testing.T) {
+	c := &fakeContext{entities: make(map[string]*pb.EntityProto)}
+	putWidgets(t, c, "", widget{"a", 1})
+
+	w := widget{"stale", 9}
+	created, err := GetOrInsert(c, NewKey(c, "Widget", "a", 0, nil), &w)
+	if err != nil || created || w != (widget{"a", 1}) {
+		t.Errorf("existing entity: got %v, %v, %v", w, created, err)
+	}
+
+	c.methods = nil
+	w = widget{"stale", 9}
+	k := NewKey(c, "Widget", "b", 0, nil)
+	created, err = GetOrInsert(c, k, &w)
+	if err != nil || !created || w != (widget{}) {
+		t.Errorf("missing entity: got %v, %v, %v", w, created, err)
+	}
+	if c.entities[k.Encode()] == nil {
+		t.Errorf("missing entity: it was not saved")
+	}
+	want := []string{"BeginTransaction", "Get", "Put", "Commit"}
+	if !reflect.DeepEqual(c.methods, want) {
+		t.Errorf("got calls %v, want %v", c.methods, want)
+	}
+}
+
+func TestCursorEncoding(t *testing.T) {
+	c := Cursor{&pb.CompiledCursor{
+		Position: []*pb.CompiledCursor_Position{
+			&pb.CompiledCursor_Position{
+				StartKey:       proto.String("next-page-start-key"),
+				StartInclusive: proto.Bool(false),
+			},
+		},
+	}}
+	encoded := c.Encode()
+	got, err := DecodeCursor(encoded)
+	if err != nil {
+		t.Fatalf("DecodeCursor: %v", err)
+	}
+	if !reflect.DeepEqual(got, c) {
+		t.Errorf("got %v, want %v", got.cc, c.cc)
+	}
+
+	for _, bad := range []string{"", encoded[:len(encoded)/2], "not a cursor!"} {
+		if _, err := DecodeCursor(bad); err == nil {
+			t.Errorf("DecodeCursor(%q): expected an error", bad)
+		}
+	}
+	if s := (Cursor{}).Encode(); s != "" {
+		t.Errorf("zero Cursor: got %q, want \"\"", s)
+	}
+}
+
+func TestKeepCursor(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		q := NewQuery("T")
+		if keep {
+			q = q.KeepCursor()
+		}
+		var req pb.Query
+		if err := q.toProto(&req, testAppID, "", zeroLimitMeansUnlimited); err != nil {
+			t.Fatalf("toProto: %v", err)
+		}
+		if got := proto.GetBool(req.Compile); got != keep {
+			t.Errorf("KeepCursor %v: got compile %v", keep, got)
+		}
+	}
+
+	c := &fakeContext{entities: make(map[string]*pb.EntityProto)}
+	t0 := NewQuery("T").Run(c)
+	if _, err := t0.Next(nil); err != Done {
+		t.Fatalf("Next: got %v, want Done", err)
+	}
+	if _, err := t0.Cursor(); err == nil {
+		t.Errorf("Cursor without KeepCursor: expected an error")
+	}
+}
+
+func TestKeyAncestry(t *testing.T) {
+	c := &fakeContext{}
+	country := NewKey(c, "Country", "fr", 0, nil)
+	city := NewKey(c, "City", "paris", 0, country)
+	street := NewKey(c, "Street", "", 42, city)
+
+	if p := street.Parent(); p != city {
+		t.Errorf("street parent: got %v, want %v", p, city)
+	}
+	if p := street.Parent().Parent(); p != country {
+		t.Errorf("city parent: got %v, want %v", p, country)
+	}
+	if p := country.Parent(); p != nil {
+		t.Errorf("country parent: got %v, want nil", p)
+	}
+	for _, k := range []*Key{street, city, country} {
+		if r := k.Root(); r != country {
+			t.Errorf("%v root: got %v, want %v", k, r, country)
+		}
+	}
+	if ns := street.Namespace(); ns != "" {
+		t.Errorf("namespace: got %q, want the default namespace", ns)
+	}
+}
+
+func TestNewKeyPath(t *testing.T) {
+	c := &fakeContext{}
+	k, err := NewKeyPath(c, "Blog", "golang", "Post", int64(42), "Comment", int64(1))
+	if err != nil {
+		t.Fatalf("NewKeyPath: %v", err)
+	}
+	want := NewKey(c, "Comment", "", 1, NewKey(c, "Post", "", 42, NewKey(c, "Blog", "golang", 0, nil)))
+	if !k.Eq(want) {
+		t.Errorf("got %v, want %v", k, want)
+	}
+	if root := k.Parent().Parent(); root.Kind() != "Blog" || root.Parent() != nil {
+		t.Errorf("root: got %v", root)
+	}
+
+	for _, pairs := range [][]interface{}{
+		{"Blog", 42},
+		{"Blog", "golang", "Post"},
+		{42, "golang"},
+		{"Blog", int64(0), "Post", int64(1)},
+	} {
+		if _, err := NewKeyPath(c, pairs...); err == nil {
+			t.Errorf("%v: expected an error", pairs)
+		}
+	}
+}
+
+func TestQueryStream(t *testing.T) {
+	c := &fakeContext{entities: make(map[string]*pb.EntityProto), pageSize: 2}
+	var encoded []string
+	for i := int64(1); i <= 5; i++ {
+		k := NewKey(c, "Widget", "", i, nil)
+		e, err := saveStruct(testAppID, k, reflect.ValueOf(widget{"w", i}))
+		if err != nil {
+			t.Fatalf("saveStruct: %v", err)
+		}
+		c.entities[k.Encode()] = e
+		encoded = append(encoded, k.Encode())
+	}
+	// The fake context returns results in encoded key order.
+	sort.Strings(encoded)
+	var keys []*Key
+	for _, s := range encoded {
+		k, err := DecodeKey(s)
+		if err != nil {
+			t.Fatalf("DecodeKey: %v", err)
+		}
+		keys = append(keys, k)
+	}
+
+	results, cancel := NewQuery("Widget").Stream(c)
+	i := 0
+	for r := range results {
+		var w widget
+		if err := r.Load(&w); err != nil {
+			t.Fatalf("result %d: %v", i, err)
+		}
+		if i >= len(keys) || !r.Key.Eq(keys[i]) || w.Price != r.Key.IntID() {
+			t.Errorf("result %d: got %v, %v", i, r.Key, w)
+		}
+		i++
+	}
+	cancel()
+	if i != len(keys) {
+		t.Errorf("got %d results, want %d", i, len(keys))
+	}
+	if want := []string{"RunQuery", "Next", "Next"}; !reflect.DeepEqual(c.methods, want) {
+		t.Errorf("methods: got %v, want %v", c.methods, want)
+	}
+
+	// Cancelling after the first result must not fetch the second page.
+	c.methods = nil
+	results, cancel = NewQuery("Widget").Stream(c)
+	if r := <-results; r.Err != nil || !r.Key.Eq(keys[0]) {
+		t.Fatalf("first result: got %v, %v", r.Key, r.Err)
+	}
+	cancel()
+	cancel()
+	for _ = range results {
+	}
+	if want := []string{"RunQuery"}; !reflect.DeepEqual(c.methods, want) {
+		t.Errorf("methods after cancel: got %v, want %v", c.methods, want)
+	}
+}
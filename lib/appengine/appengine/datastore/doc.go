@@ -10,6 +10,11 @@ consists of an optional parent key, a string application ID, a string kind
 (also known as an entity type), and either a StringID or an IntID. A
 StringID is also known as an entity name or key name.
 
+Keys are also in a namespace, which lets an application isolate the data of
+different tenants. Keys created with a context returned by appengine.Namespace,
+and queries run with it, are in that namespace. Otherwise they are in the
+default namespace, "".
+
 It is valid to create a key with a zero StringID and a zero IntID; this is
 called an incomplete key, and does not refer to any saved entity. Putting an
 entity into the datastore under an incomplete key will cause a unique key
@@ -24,8 +29,12 @@ Valid value types are:
   - any type whose underlying type is one of the above predeclared types,
   - *Key,
   - appengine.BlobKey,
-  - []byte (up to 1 megabyte in length),
-  - slices of any of the above.
+  - Time, Email, Link, Category and Rating, which are stored with a meaning
+    that App Engine tools use to display them (a Map loads Email, Link,
+    Category and Rating as string and int64 values),
+  - []byte (up to 1 megabyte in length) and types whose underlying type is
+    []byte,
+  - slices of any of the above, including named slice types.
 
 The Get and Put functions load and save an entity's contents to and from
 structs or Maps. Structs are more strongly typed, Maps are more flexible. The
@@ -37,6 +46,33 @@ a field-by-field basis. When loading into a struct, an entity that cannot be
 completely represented (such as a missing field) will result in an error but it
 is up to the caller whether this error is fatal, recoverable or ignorable.
 
+By default, a struct field is stored as a property with the same name as the
+field. A struct tag can override this: a field tagged `datastore:"created"`
+is saved and loaded as the "created" property, a field tagged `datastore:"-"`
+is ignored, and the "noindex" option, as in `datastore:"created,noindex"` or
+`datastore:",noindex"`, stores the property unindexed. Entities saved before
+a field was renamed by a tag can still be loaded, since a property named like
+the Go field is loaded into it if no other field claims that name.
+
+A slice field, other than []byte, is stored as a single multiple-valued
+property holding one value per element, in order, and is loaded back by
+appending each value to the slice. An equality filter on such a property
+matches entities with any element equal to the filter value. The "flatten"
+option, as in `datastore:"Tags,flatten"`, makes this explicit: it is an
+error to use it on a field that is not a slice.
+
+A field that is a pointer to a struct, other than *Key, is stored as the
+nested struct's fields, each named with the outer field's name and a dot, as
+in "Address.City". A nil pointer saves no properties, and when loading, the
+nested struct is allocated only if the entity has properties for it. Saving
+a struct whose pointers form a cycle returns an error.
+
+A field of type map[string]interface{} or Map is stored like a Map entity,
+one property per entry, named with the field's name and a dot, as in
+"Attrs.color". Entries hold the same value types as a Map; nil entries are
+not saved. When loading, the map is allocated if the entity has properties
+for it.
+
 Example code:
 
 	type Entity struct {
@@ -67,16 +103,27 @@ Example code:
 
 To derive example code that saves and loads a Map instead of a struct, replace
 e := new(Entity) and e.Value with e := make(datastore.Map) and e["Value"].
+Methods such as Map.String and Map.Key read an entry of a given type without
+a type assertion: e.String("Value") returns the value and whether it is a
+string.
 
 GetMulti, PutMulti and DeleteMulti are batch versions of the Get, Put and
 Delete functions. They take a []*Key instead of a *Key, and may return an
-ErrMulti when encountering partial failure.
+ErrMulti when encountering partial failure. GetAllByKey is like GetMulti but
+loads into a slice of structs, struct pointers or Maps, such as a []*Widget.
+GetWithOptions can ignore stored properties that the destination struct has
+no field for. PutMultiWithOptions can store all properties unindexed, for
+faster bulk imports. GetOrInsert loads an entity, or saves an empty one in a
+transaction if it doesn't exist yet.
 
 Queries are created using datastore.NewQuery and are configured
 by calling its methods. Running a query yields an iterator of
 results: either an iterator of keys or of (key, entity) pairs. Once
 initialized, query values can be re-used, and it is safe to call
-Query.Run from concurrent goroutines.
+Query.Run from concurrent goroutines. Filters are combined with AND; to
+combine queries with OR, use Query.Or, which runs each of them in turn.
+Query.Keys returns the keys of the entities that match a query, and
+Query.DeleteAll deletes them.
 
 Example code:
 
@@ -107,6 +154,16 @@ Example code:
 		io.Copy(w, b)
 	}
 
+To fetch results a page at a time, run a query with a limit and KeepCursor,
+iterate until Done and keep the Iterator's Cursor. Passing that cursor to
+Query.Start on the same query continues where the previous page ended.
+Cursor.Encode and DecodeCursor convert a cursor to and from a string, to
+pass it between requests.
+
+Query.Stream sends a query's results on a channel instead, fetching each
+batch as the previous one is received. The caller must receive until the
+channel is closed or call the cancel function that Stream returns.
+
 RunInTransaction runs a function in a transaction.
 
 Example code:
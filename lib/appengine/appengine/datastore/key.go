@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"gob"
 	"strconv"
 	"strings"
@@ -20,11 +21,12 @@ import (
 
 // Key represents the datastore key for a stored entity, and is immutable.
 type Key struct {
-	kind     string
-	stringID string
-	intID    int64
-	parent   *Key
-	appID    string
+	kind      string
+	stringID  string
+	intID     int64
+	parent    *Key
+	appID     string
+	namespace string
 }
 
 // Kind returns the key's kind (also known as entity type).
@@ -53,6 +55,12 @@ func (k *Key) AppID() string {
 	return k.appID
 }
 
+// Namespace returns the key's namespace, which is "" for the default
+// namespace.
+func (k *Key) Namespace() string {
+	return k.namespace
+}
+
 // Incomplete returns whether the key does not refer to a stored entity.
 // In particular, whether the key has a zero StringID and a zero IntID.
 func (k *Key) Incomplete() bool {
@@ -75,7 +83,7 @@ func (k *Key) valid() bool {
 			if k.parent.Incomplete() {
 				return false
 			}
-			if k.parent.appID != k.appID {
+			if k.parent.appID != k.appID || k.parent.namespace != k.namespace {
 				return false
 			}
 		}
@@ -86,7 +94,7 @@ func (k *Key) valid() bool {
 // Eq returns whether two keys are equal.
 func (k *Key) Eq(o *Key) bool {
 	for k != nil && o != nil {
-		if k.kind != o.kind || k.stringID != o.stringID || k.intID != o.intID || k.appID != o.appID {
+		if k.kind != o.kind || k.stringID != o.stringID || k.intID != o.intID || k.appID != o.appID || k.namespace != o.namespace {
 			return false
 		}
 		k, o = k.parent, o.parent
@@ -94,8 +102,9 @@ func (k *Key) Eq(o *Key) bool {
 	return k == o
 }
 
-// root returns the furthest ancestor of a key, which may be itself.
-func (k *Key) root() *Key {
+// Root returns the furthest ancestor of a key, which may be itself. It
+// identifies the key's entity group.
+func (k *Key) Root() *Key {
 	for k.parent != nil {
 		k = k.parent
 	}
@@ -128,11 +137,12 @@ func (k *Key) String() string {
 }
 
 type gobKey struct {
-	Kind     string
-	StringID string
-	IntID    int64
-	Parent   *gobKey
-	AppID    string
+	Kind      string
+	StringID  string
+	IntID     int64
+	Parent    *gobKey
+	AppID     string
+	Namespace string
 }
 
 func keyToGobKey(k *Key) *gobKey {
@@ -140,11 +150,12 @@ func keyToGobKey(k *Key) *gobKey {
 		return nil
 	}
 	return &gobKey{
-		Kind:     k.kind,
-		StringID: k.stringID,
-		IntID:    k.intID,
-		Parent:   keyToGobKey(k.parent),
-		AppID:    k.appID,
+		Kind:      k.kind,
+		StringID:  k.stringID,
+		IntID:     k.intID,
+		Parent:    keyToGobKey(k.parent),
+		AppID:     k.appID,
+		Namespace: k.namespace,
 	}
 }
 
@@ -153,11 +164,12 @@ func gobKeyToKey(gk *gobKey) *Key {
 		return nil
 	}
 	return &Key{
-		kind:     gk.Kind,
-		stringID: gk.StringID,
-		intID:    gk.IntID,
-		parent:   gobKeyToKey(gk.Parent),
-		appID:    gk.AppID,
+		kind:      gk.Kind,
+		stringID:  gk.StringID,
+		intID:     gk.IntID,
+		parent:    gobKeyToKey(gk.Parent),
+		appID:     gk.AppID,
+		namespace: gk.Namespace,
 	}
 }
 
@@ -239,13 +251,62 @@ func NewIncompleteKey(c appengine.Context, kind string, parent *Key) *Key {
 // kind cannot be empty.
 // Either one or both of stringID and intID must be zero. If both are zero,
 // the key returned is incomplete.
-// parent must either be a complete key or nil.
+// parent must either be a complete key or nil, and be in the same namespace.
+// The key is in the namespace of the context: see appengine.Namespace.
 func NewKey(c appengine.Context, kind, stringID string, intID int64, parent *Key) *Key {
 	return &Key{
-		kind:     kind,
-		stringID: stringID,
-		intID:    intID,
-		parent:   parent,
-		appID:    c.FullyQualifiedAppID(),
+		kind:      kind,
+		stringID:  stringID,
+		intID:     intID,
+		parent:    parent,
+		appID:     c.FullyQualifiedAppID(),
+		namespace: contextNamespace(c),
+	}
+}
+
+// NewKeyPath creates a key from a path of alternating kinds and IDs, starting
+// at the root. Each kind is a string and each ID is either a string ID or an
+// int64 integer ID. For example, this creates a Comment key whose parent is
+// a Post key, itself a child of a Blog key:
+//
+//	k, err := datastore.NewKeyPath(c, "Blog", "golang", "Post", int64(42), "Comment", int64(1))
+//
+// Only the last ID can be zero or empty, giving an incomplete key.
+func NewKeyPath(c appengine.Context, pairs ...interface{}) (*Key, error) {
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return nil, errors.New("datastore: key path requires kind and ID pairs")
+	}
+	var k *Key
+	for i := 0; i < len(pairs); i += 2 {
+		kind, ok := pairs[i].(string)
+		if !ok || kind == "" {
+			return nil, fmt.Errorf("datastore: key path element %d: kind must be a non-empty string, got %#v", i, pairs[i])
+		}
+		var stringID string
+		var intID int64
+		switch id := pairs[i+1].(type) {
+		case string:
+			stringID = id
+		case int64:
+			intID = id
+		default:
+			return nil, fmt.Errorf("datastore: key path element %d: ID must be a string or an int64, got %T", i+1, pairs[i+1])
+		}
+		if k != nil && k.Incomplete() {
+			return nil, errors.New("datastore: key path has an incomplete parent key")
+		}
+		k = NewKey(c, kind, stringID, intID, k)
+	}
+	return k, nil
+}
+
+// contextNamespace returns the namespace of the context c, which is set by
+// appengine.Namespace.
+func contextNamespace(c appengine.Context) string {
+	if nc, ok := c.(interface {
+		Namespace() string
+	}); ok {
+		return nc.Namespace()
 	}
+	return ""
 }
@@ -7,6 +7,7 @@ package datastore
 import (
 	"fmt"
 	"reflect"
+	"strings"
 	"unicode"
 	"utf8"
 
@@ -16,6 +17,10 @@ import (
 	pb "appengine_internal/datastore"
 )
 
+// noSuchFieldReason is the reason given for a property that has no field in
+// the destination struct.
+const noSuchFieldReason = "no such struct field"
+
 // typeMismatchReason returns a string explaining why the property p could not
 // be stored in an entity field of type v.Type().
 func typeMismatchReason(p *pb.Property, v reflect.Value) string {
@@ -46,18 +51,100 @@ func unexported(fieldName string) bool {
 	return !unicode.IsUpper(firstRune)
 }
 
-// loadStructField converts a Property into a field of an existing struct,
-// or into an element of a slice-typed struct field.
+// keyType is the type of a *Key, which is a pointer to a struct but is
+// stored as a single property rather than as a nested struct.
+var keyType = reflect.TypeOf((*Key)(nil))
+
+// isNestedStruct returns whether t is a pointer to a struct that is saved
+// and loaded as a set of "Field.SubField" properties.
+func isNestedStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct && t != keyType
+}
+
+// entityMapTypes are the map types whose entries are saved and loaded as a
+// set of "Field.Name" properties, like the entries of a Map entity.
+var entityMapTypes = []reflect.Type{
+	reflect.TypeOf(Map(nil)),
+	reflect.TypeOf(map[string]interface{}(nil)),
+}
+
+// isEntityMap returns whether t is a Map or a map[string]interface{}.
+func isEntityMap(t reflect.Type) bool {
+	for _, mt := range entityMapTypes {
+		if t == mt {
+			return true
+		}
+	}
+	return false
+}
+
+// isBlob returns whether t is []byte or a type whose underlying type is
+// []byte. Such values are stored as a single blob property, not as a
+// multiple-valued property.
+func isBlob(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8
+}
+
+// loadNestedField converts a Property named "Field.SubField" into the nested
+// struct pointed to by field f of sv, allocating that struct if the pointer
+// is nil. fieldName is the name with the "Field." prefix removed.
+// It returns an error message, or "" for success.
+func loadNestedField(f fieldCodec, sv reflect.Value, fieldName string, p *pb.Property) string {
+	v := sv.Field(f.index)
+	if isEntityMap(v.Type()) {
+		return loadEntityMapField(v, fieldName, p)
+	}
+	if !isNestedStruct(v.Type()) {
+		return noSuchFieldReason
+	}
+	codec, err := getStructCodec(v.Type().Elem())
+	if err != nil {
+		return err.Error()
+	}
+	if v.IsNil() {
+		v.Set(reflect.New(v.Type().Elem()))
+	}
+	return loadStructField(codec, v.Elem(), fieldName, p)
+}
+
+// loadEntityMapField converts a Property named "Field.Name" into the entry
+// Name of the map field v, allocating the map if it is nil. fieldName is the
+// name with the "Field." prefix removed.
 // It returns an error message, or "" for success.
-func loadStructField(sv reflect.Value, p *pb.Property) string {
-	fieldName := proto.GetString(p.Name)
-	v := sv.FieldByName(fieldName)
-	if !v.IsValid() {
-		return "no such struct field"
+func loadEntityMapField(v reflect.Value, fieldName string, p *pb.Property) string {
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(v.Type()))
+	}
+	m, ok := v.Interface().(Map)
+	if !ok {
+		m = Map(v.Interface().(map[string]interface{}))
 	}
-	if unexported(fieldName) {
-		return "unexported struct field"
+	entry := *p
+	entry.Name = proto.String(fieldName)
+	if err := loadMapEntry(m, nil, &entry); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// loadStructField converts a Property into a field of an existing struct,
+// or into an element of a slice-typed struct field. fieldName is the name
+// of the property relative to sv.
+// It returns an error message, or "" for success.
+func loadStructField(codec *structCodec, sv reflect.Value, fieldName string, p *pb.Property) string {
+	i, ok := codec.byName[fieldName]
+	if !ok {
+		if j := strings.Index(fieldName, "."); j >= 0 {
+			if i, ok := codec.byName[fieldName[:j]]; ok {
+				return loadNestedField(codec.fields[i], sv, fieldName[j+1:], p)
+			}
+		}
+		if unexported(fieldName) && sv.FieldByName(fieldName).IsValid() {
+			return "unexported struct field"
+		}
+		return noSuchFieldReason
 	}
+	v := sv.Field(codec.fields[i].index)
 	var slice reflect.Value
 	if proto.GetBool(p.Multiple) {
 		if v.Kind() != reflect.Slice {
@@ -108,14 +195,13 @@ func loadStructField(sv reflect.Value, p *pb.Property) string {
 		}
 		v.Set(reflect.ValueOf(k))
 	case reflect.Slice:
-		if _, ok := v.Interface().([]byte); !ok {
+		if !isBlob(v.Type()) {
 			return typeMismatchReason(p, v)
 		}
 		if p.Value.StringValue == nil {
 			return typeMismatchReason(p, v)
 		}
-		b := []byte(*p.Value.StringValue)
-		v.Set(reflect.ValueOf(b))
+		v.SetBytes([]byte(*p.Value.StringValue))
 	default:
 		return typeMismatchReason(p, v)
 	}
@@ -126,17 +212,31 @@ func loadStructField(sv reflect.Value, p *pb.Property) string {
 }
 
 // loadStruct converts an EntityProto into an existing struct.
+// Nil pointers to nested structs are allocated only if the entity has
+// properties for them.
 // It returns an error if the destination struct is unable to hold the entity.
 func loadStruct(sv reflect.Value, k *Key, e *pb.EntityProto) error {
-	var fieldName, reason string
-	for _, p := range e.Property {
-		if errStr := loadStructField(sv, p); errStr != "" {
-			fieldName, reason = proto.GetString(p.Name), errStr
-		}
+	return loadStructWithOptions(sv, k, e, nil)
+}
+
+// loadStructWithOptions is like loadStruct, with options. The opts parameter
+// may be nil.
+func loadStructWithOptions(sv reflect.Value, k *Key, e *pb.EntityProto, opts *GetOptions) error {
+	codec, err := getStructCodec(sv.Type())
+	if err != nil {
+		return err
 	}
-	for _, p := range e.RawProperty {
-		if errStr := loadStructField(sv, p); errStr != "" {
-			fieldName, reason = proto.GetString(p.Name), errStr
+	ignoreUnknown := opts != nil && opts.IgnoreUnknownFields
+	var fieldName, reason string
+	for _, props := range [][]*pb.Property{e.Property, e.RawProperty} {
+		for _, p := range props {
+			errStr := loadStructField(codec, sv, proto.GetString(p.Name), p)
+			if errStr == noSuchFieldReason && ignoreUnknown {
+				continue
+			}
+			if errStr != "" {
+				fieldName, reason = proto.GetString(p.Name), errStr
+			}
 		}
 	}
 	if reason != "" {
@@ -0,0 +1,100 @@
+// Copyright 2011 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package datastore
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// fieldCodec describes how a single struct field maps to a datastore property.
+type fieldCodec struct {
+	// index is the index of the field in the struct.
+	index int
+	// name is the datastore property name, which is the field name unless
+	// overridden by a struct tag.
+	name string
+	// noIndex is whether the property should be stored unindexed.
+	noIndex bool
+}
+
+// structCodec describes how a struct type is converted to and from an entity.
+type structCodec struct {
+	// fields are the saveable fields, in declaration order.
+	fields []fieldCodec
+	// byName maps a property name to an index into fields. It also maps
+	// the Go name of a renamed field, so that entities stored before the
+	// field was renamed can still be loaded.
+	byName map[string]int
+}
+
+var (
+	structCodecsMutex sync.Mutex
+	structCodecs      = make(map[reflect.Type]*structCodec)
+)
+
+// getStructCodec returns the structCodec for the given struct type, building
+// and caching it on first use.
+func getStructCodec(t reflect.Type) (*structCodec, error) {
+	structCodecsMutex.Lock()
+	defer structCodecsMutex.Unlock()
+	if c, ok := structCodecs[t]; ok {
+		return c, nil
+	}
+	c := &structCodec{byName: make(map[string]int)}
+	// renamed holds the Go names of fields whose property name was changed
+	// by a struct tag, keyed by Go name.
+	renamed := make(map[string]int)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if unexported(f.Name) {
+			continue
+		}
+		name, opts := f.Tag.Get("datastore"), ""
+		if j := strings.Index(name, ","); j >= 0 {
+			name, opts = name[:j], name[j+1:]
+		}
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fc := fieldCodec{index: i, name: name}
+		if opts != "" {
+			for _, opt := range strings.Split(opts, ",") {
+				switch opt {
+				case "noindex":
+					fc.noIndex = true
+				case "flatten":
+					// Slices are always stored as a multiple-valued
+					// property; the option only documents and checks it.
+					if f.Type.Kind() != reflect.Slice || isBlob(f.Type) {
+						return nil, fmt.Errorf("datastore: struct tag for field %q has option %q but the field is not a slice", f.Name, opt)
+					}
+				default:
+					return nil, fmt.Errorf("datastore: struct tag for field %q has unknown option %q", f.Name, opt)
+				}
+			}
+		}
+		if _, ok := c.byName[name]; ok {
+			return nil, fmt.Errorf("datastore: struct tag has repeated property name: %q", name)
+		}
+		c.byName[name] = len(c.fields)
+		if name != f.Name {
+			renamed[f.Name] = len(c.fields)
+		}
+		c.fields = append(c.fields, fc)
+	}
+	for goName, j := range renamed {
+		if _, ok := c.byName[goName]; !ok {
+			c.byName[goName] = j
+		}
+	}
+	structCodecs[t] = c
+	return c, nil
+}
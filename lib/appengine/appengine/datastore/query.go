@@ -10,6 +10,7 @@ import (
 	"math"
 	"reflect"
 	"strings"
+	"sync"
 
 	"appengine"
 	"goprotobuf.googlecode.com/hg/proto"
@@ -80,6 +81,22 @@ type Query struct {
 	keysOnly bool
 	limit    int32
 	offset   int32
+	start    *pb.CompiledCursor
+	// compile is whether the query asks for a cursor, see KeepCursor.
+	compile bool
+
+	// namespace is the query's namespace, if namespaceSet is true.
+	// Otherwise the query runs in the namespace of the context.
+	namespace    string
+	namespaceSet bool
+
+	// eventual is the read consistency of an ancestor query, if
+	// consistencySet is true. Otherwise the datastore default applies.
+	eventual       bool
+	consistencySet bool
+
+	// or holds the alternative queries, see Or.
+	or []*Query
 
 	err error
 }
@@ -95,22 +112,47 @@ func (q *Query) Ancestor(ancestor *Key) *Query {
 	return q
 }
 
+// keyFieldName is the special property name used to filter and sort by key.
+const keyFieldName = "__key__"
+
 // Filter adds a field-based filter to the Query.
 // The filterStr argument must be a field name followed by optional space,
 // followed by an operator, one of ">", "<", ">=", "<=", or "=".
 // Fields are compared against the provided value using the operator.
 // Multiple filters are AND'ed together.
 // The Query is updated in place and returned for ease of chaining.
+//
+// An equality filter on a multiple-valued (slice) property matches entities
+// with at least one value equal to the provided value, which can't be a
+// slice. To find the entities that have several values, add one equality
+// filter per value; the datastore evaluates them with a merge join:
+//
+//	q.Filter("Tag =", "a").Filter("Tag =", "b")
+//
+// To filter by key, use the field name "__key__" with a complete *Key value,
+// such as the last key of a page of results:
+//
+//	q.Filter("__key__ >", lastKey).Order("__key__")
 func (q *Query) Filter(filterStr string, value interface{}) *Query {
 	filterStr = strings.TrimSpace(filterStr)
 	if len(filterStr) < 1 {
 		q.err = errors.New("datastore: invalid filter: " + filterStr)
 		return q
 	}
+	if _, ok := value.([]byte); !ok && reflect.ValueOf(value).Kind() == reflect.Slice {
+		q.err = fmt.Errorf("datastore: invalid filter value of type %T in filter %q: use one filter per value", value, filterStr)
+		return q
+	}
 	f := filter{
 		FieldName: strings.TrimRight(filterStr, " ><="),
 		Value:     value,
 	}
+	if f.FieldName == keyFieldName {
+		if k, ok := value.(*Key); !ok || k == nil || k.Incomplete() {
+			q.err = fmt.Errorf("datastore: invalid filter value of type %T in filter %q: requires a complete *Key", value, filterStr)
+			return q
+		}
+	}
 	switch op := strings.TrimSpace(filterStr[len(f.FieldName):]); op {
 	case "<=":
 		f.Op = lessEq
@@ -134,6 +176,7 @@ func (q *Query) Filter(filterStr string, value interface{}) *Query {
 // Orders are applied in the order they are added.
 // The default order is ascending; to sort in descending
 // order prefix the fieldName with a minus sign (-).
+// The field name "__key__" sorts by key.
 func (q *Query) Order(fieldName string) *Query {
 	fieldName = strings.TrimSpace(fieldName)
 	o := order{Direction: ascending, FieldName: fieldName}
@@ -152,6 +195,46 @@ func (q *Query) Order(fieldName string) *Query {
 	return q
 }
 
+// Or returns a query for the entities that match either q or any of the
+// given queries, such as two queries with different filters on the same kind.
+//
+// The datastore has no native OR, so running the combined query runs each
+// query in turn: it costs as much as running all of them. The results of q
+// come first, in q's order, followed by the results of each other query that
+// were not already returned. The limit, offset and order of each query apply
+// to that query only, and all queries must either be keys-only or not.
+func (q *Query) Or(queries ...*Query) *Query {
+	q.or = append(q.or, queries...)
+	return q
+}
+
+// Namespace sets the namespace the query runs in, overriding the namespace
+// of the context it is run with. The empty string is the default namespace.
+func (q *Query) Namespace(namespace string) *Query {
+	q.namespace = namespace
+	q.namespaceSet = true
+	return q
+}
+
+// EventualConsistency sets whether an ancestor query may return eventually
+// consistent results. Ancestor queries are strongly consistent by default;
+// eventually consistent ones may miss recent writes but are faster. It has no
+// effect on queries without an ancestor, which are always eventually
+// consistent.
+func (q *Query) EventualConsistency(eventual bool) *Query {
+	q.eventual = eventual
+	q.consistencySet = true
+	return q
+}
+
+// namespaceIn returns the namespace the query runs in with the context c.
+func (q *Query) namespaceIn(c appengine.Context) string {
+	if q.namespaceSet {
+		return q.namespace
+	}
+	return contextNamespace(c)
+}
+
 // KeysOnly configures the query to return just keys,
 // instead of keys and entities.
 func (q *Query) KeysOnly() *Query {
@@ -202,15 +285,22 @@ const (
 )
 
 // toProto converts the query to a protocol buffer.
-func (q *Query) toProto(dst *pb.Query, appID string, zlp zeroLimitPolicy) error {
+// An empty namespace is the default namespace.
+func (q *Query) toProto(dst *pb.Query, appID, namespace string, zlp zeroLimitPolicy) error {
 	if q.kind == "" {
 		return errors.New("datastore: empty query kind")
 	}
 	dst.Reset()
 	dst.App = proto.String(appID)
+	if namespace != "" {
+		dst.NameSpace = proto.String(namespace)
+	}
 	dst.Kind = proto.String(q.kind)
 	if q.ancestor != nil {
 		dst.Ancestor = keyToProto(appID, q.ancestor)
+		if q.consistencySet {
+			dst.Strong = proto.Bool(!q.eventual)
+		}
 	}
 	if q.keysOnly {
 		dst.KeysOnly = proto.Bool(true)
@@ -252,6 +342,12 @@ func (q *Query) toProto(dst *pb.Query, appID string, zlp zeroLimitPolicy) error
 	if q.offset != 0 {
 		dst.Offset = proto.Int32(q.offset)
 	}
+	if q.start != nil {
+		dst.CompiledCursor = q.start
+	}
+	if q.compile {
+		dst.Compile = proto.Bool(true)
+	}
 	return nil
 }
 
@@ -261,6 +357,9 @@ func (q *Query) Count(c appengine.Context) (int, error) {
 	if q.err != nil {
 		return 0, q.err
 	}
+	if len(q.or) > 0 {
+		return q.countOr(c)
+	}
 
 	// Run a copy of the query, with keysOnly true, and an adjusted offset.
 	// We also set the limit to zero, as we don't want any actual entity data,
@@ -279,7 +378,7 @@ func (q *Query) Count(c appengine.Context) (int, error) {
 		}
 	}
 	req := &pb.Query{}
-	if err := newQ.toProto(req, c.FullyQualifiedAppID(), zeroLimitMeansZero); err != nil {
+	if err := newQ.toProto(req, c.FullyQualifiedAppID(), q.namespaceIn(c), zeroLimitMeansZero); err != nil {
 		return 0, err
 	}
 	res := &pb.QueryResult{}
@@ -325,6 +424,32 @@ func (q *Query) Count(c appengine.Context) (int, error) {
 	return int(n), nil
 }
 
+// keysOnlyCopy returns a keys-only copy of the query, including the
+// queries combined with it by Or.
+func (q *Query) keysOnlyCopy() *Query {
+	kq := *q
+	kq.keysOnly = true
+	kq.or = make([]*Query, len(q.or))
+	for i, sq := range q.or {
+		kq.or[i] = sq.keysOnlyCopy()
+	}
+	return &kq
+}
+
+// countOr returns the number of results for a query with Or, by counting
+// the distinct keys returned by the keys-only version of all queries.
+func (q *Query) countOr(c appengine.Context) (int, error) {
+	n := 0
+	for t := q.keysOnlyCopy().Run(c); ; n++ {
+		if _, _, err := t.next(); err == Done {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
 // callNext issues a datastore_v3/Next RPC to advance a cursor, such as that
 // returned by a query with more results.
 func callNext(c appengine.Context, res *pb.QueryResult, offset, limit int32, zlp zeroLimitPolicy) error {
@@ -409,8 +534,70 @@ func (q *Query) GetAll(c appengine.Context, dst interface{}) ([]*Key, error) {
 	return keys, nil
 }
 
+// Keys runs the keys-only version of the query in the given context and
+// returns the keys that match it. The query itself is not changed, so it
+// doesn't matter whether KeysOnly was called on it.
+func (q *Query) Keys(c appengine.Context) ([]*Key, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+	return q.keysOnlyCopy().GetAll(c, nil)
+}
+
+// maxDeleteBatch is the maximum number of keys deleted by a single
+// DeleteMulti call in DeleteAll.
+const maxDeleteBatch = 500
+
+// DeleteAll deletes the entities that match the query and returns how many
+// were deleted. It runs the keys-only version of the query and deletes the
+// keys in batches of up to 500 per RPC. On error, the returned count is the
+// number of entities deleted before the failure.
+func (q *Query) DeleteAll(c appengine.Context) (int, error) {
+	if q.err != nil {
+		return 0, q.err
+	}
+	n := 0
+	batch := make([]*Key, 0, maxDeleteBatch)
+	for t := q.keysOnlyCopy().Run(c); ; {
+		k, _, err := t.next()
+		if err != nil && err != Done {
+			return n, err
+		}
+		if err == nil {
+			batch = append(batch, k)
+		}
+		if len(batch) == maxDeleteBatch || (err == Done && len(batch) > 0) {
+			if err := DeleteMulti(c, batch); err != nil {
+				return n, err
+			}
+			n += len(batch)
+			batch = batch[:0]
+		}
+		if err == Done {
+			return n, nil
+		}
+	}
+	panic("unreachable")
+}
+
 // Run runs the query in the given context.
 func (q *Query) Run(c appengine.Context) *Iterator {
+	t := q.run(c)
+	if len(q.or) > 0 && t.err == nil {
+		for _, sq := range q.or {
+			if sq.keysOnly != q.keysOnly {
+				t.err = errors.New("datastore: queries combined with Or must all be keys-only or not")
+				return t
+			}
+		}
+		t.or = q.or
+		t.seen = make(map[string]bool)
+	}
+	return t
+}
+
+// run runs the query in the given context, ignoring alternative queries.
+func (q *Query) run(c appengine.Context) *Iterator {
 	if q.err != nil {
 		return &Iterator{err: q.err}
 	}
@@ -420,7 +607,7 @@ func (q *Query) Run(c appengine.Context) *Iterator {
 		limit:  q.limit,
 	}
 	var req pb.Query
-	if err := q.toProto(&req, c.FullyQualifiedAppID(), zeroLimitMeansUnlimited); err != nil {
+	if err := q.toProto(&req, c.FullyQualifiedAppID(), q.namespaceIn(c), zeroLimitMeansUnlimited); err != nil {
 		t.err = err
 		return t
 	}
@@ -438,6 +625,10 @@ type Iterator struct {
 	limit  int32
 	res    pb.QueryResult
 	err    error
+	// or holds the queries still to run after this one, for a Query with Or.
+	or []*Query
+	// seen holds the encoded keys already returned, for a Query with Or.
+	seen map[string]bool
 }
 
 // Done is returned when a query iteration has completed.
@@ -457,7 +648,30 @@ func (t *Iterator) Next(dst interface{}) (*Key, error) {
 	return loadEntity(dst, k, e)
 }
 
+// next returns the next result, running the alternative queries of a Query
+// with Or as needed and skipping the results already returned.
 func (t *Iterator) next() (*Key, *pb.EntityProto, error) {
+	for {
+		k, e, err := t.next1()
+		if err == Done && len(t.or) > 0 {
+			or, seen := t.or[1:], t.seen
+			*t = *t.or[0].run(t.c)
+			t.or, t.seen = or, seen
+			continue
+		}
+		if err != nil || t.seen == nil {
+			return k, e, err
+		}
+		if encoded := k.Encode(); !t.seen[encoded] {
+			t.seen[encoded] = true
+			return k, e, nil
+		}
+	}
+	panic("unreachable")
+}
+
+// next1 returns the next result of the query being run.
+func (t *Iterator) next1() (*Key, *pb.EntityProto, error) {
 	if t.err != nil {
 		return nil, nil, t.err
 	}
@@ -519,3 +733,68 @@ func loadEntity(dst interface{}, k *Key, e *pb.EntityProto) (*Key, error) {
 	}
 	return k, loadStruct(sv, k, e)
 }
+
+// StreamResult is a result sent by Query.Stream. Err is non-nil if the query
+// failed, in which case it is the last result sent.
+type StreamResult struct {
+	Key *Key
+	Err error
+	// entity is nil for a keys only query.
+	entity *pb.EntityProto
+}
+
+// Load loads the entity of a result into the struct pointer or Map dst, with
+// the same semantics and possible errors as for the Get function. If the
+// query is keys only, it does nothing.
+func (r StreamResult) Load(dst interface{}) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	if r.entity == nil {
+		return nil
+	}
+	_, err := loadEntity(dst, r.Key, r.entity)
+	return err
+}
+
+// Stream runs the query in a new goroutine and sends its results, in order,
+// on the returned channel, which is closed after the last result. Results
+// are fetched a batch at a time, as they are received from the channel.
+//
+// The caller must either receive from the channel until it is closed or
+// call the returned cancel function, otherwise the goroutine is never
+// released. After cancel is called, no further RPCs are issued and the
+// channel is closed; it is safe to call cancel more than once.
+func (q *Query) Stream(c appengine.Context) (<-chan StreamResult, func()) {
+	results := make(chan StreamResult)
+	done := make(chan bool)
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() { close(done) })
+	}
+	go func() {
+		defer close(results)
+		t := q.Run(c)
+		for {
+			// Check for cancellation before next can issue an RPC.
+			select {
+			case <-done:
+				return
+			default:
+			}
+			k, e, err := t.next()
+			if err == Done {
+				return
+			}
+			select {
+			case results <- StreamResult{Key: k, Err: err, entity: e}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return results, cancel
+}
@@ -6,6 +6,7 @@ package datastore
 
 import (
 	"fmt"
+	"math"
 	"reflect"
 
 	"appengine"
@@ -31,7 +32,13 @@ func valueToProto(defaultAppID, name string, v reflect.Value, multiple bool) (p
 	case reflect.String:
 		pv.StringValue = proto.String(v.String())
 	case reflect.Float32, reflect.Float64:
-		pv.DoubleValue = proto.Float64(v.Float())
+		// The datastore doesn't order NaN and infinities consistently with
+		// other floats, so they are rejected before reaching the server.
+		f := v.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Sprintf("unsupported float value %v", f)
+		}
+		pv.DoubleValue = proto.Float64(f)
 	case reflect.Ptr:
 		if k, ok := v.Interface().(*Key); ok {
 			if k == nil {
@@ -42,8 +49,11 @@ func valueToProto(defaultAppID, name string, v reflect.Value, multiple bool) (p
 			unsupported = true
 		}
 	case reflect.Slice:
-		if b, ok := v.Interface().([]byte); ok {
-			pv.StringValue = proto.String(string(b))
+		if isBlob(v.Type()) {
+			if v.Len() > maxBlobLen {
+				return nil, fmt.Sprintf("[]byte value of %d bytes is longer than the limit of %d bytes", v.Len(), maxBlobLen)
+			}
+			pv.StringValue = proto.String(string(v.Bytes()))
 		} else {
 			// nvToProto should already catch slice values.
 			// If we get here, we have a slice of slice values.
@@ -60,22 +70,35 @@ func valueToProto(defaultAppID, name string, v reflect.Value, multiple bool) (p
 		Value:    &pv,
 		Multiple: proto.Bool(multiple),
 	}
-	switch v.Interface().(type) {
-	case []byte:
+	if isBlob(v.Type()) {
 		p.Meaning = pb.NewProperty_Meaning(pb.Property_BLOB)
+	}
+	switch x := v.Interface().(type) {
 	case appengine.BlobKey:
 		p.Meaning = pb.NewProperty_Meaning(pb.Property_BLOBKEY)
 	case Time:
 		p.Meaning = pb.NewProperty_Meaning(pb.Property_GD_WHEN)
+	case Email:
+		p.Meaning = pb.NewProperty_Meaning(pb.Property_GD_EMAIL)
+	case Link:
+		p.Meaning = pb.NewProperty_Meaning(pb.Property_ATOM_LINK)
+	case Category:
+		p.Meaning = pb.NewProperty_Meaning(pb.Property_ATOM_CATEGORY)
+	case Rating:
+		if x < 0 || x > 100 {
+			return nil, fmt.Sprintf("rating %d is not between 0 and 100", x)
+		}
+		p.Meaning = pb.NewProperty_Meaning(pb.Property_GD_RATING)
 	}
 	return p, ""
 }
 
 // addProperty adds propProto to e, as either a Property or a RawProperty of e
 // depending on whether or not the property should be indexed.
-// In particular, []byte values are raw. All other values are indexed.
-func addProperty(e *pb.EntityProto, propProto *pb.Property, propValue reflect.Value) {
-	if _, ok := propValue.Interface().([]byte); ok {
+// In particular, []byte values and noIndex properties are raw. All other
+// values are indexed.
+func addProperty(e *pb.EntityProto, propProto *pb.Property, propValue reflect.Value, noIndex bool) {
+	if isBlob(propValue.Type()) || noIndex {
 		e.RawProperty = append(e.RawProperty, propProto)
 	} else {
 		e.Property = append(e.Property, propProto)
@@ -84,8 +107,9 @@ func addProperty(e *pb.EntityProto, propProto *pb.Property, propValue reflect.Va
 
 // nameValue holds a string name and a reflect.Value.
 type nameValue struct {
-	name  string
-	value reflect.Value
+	name    string
+	value   reflect.Value
+	noIndex bool
 }
 
 // nvToProto converts a slice of nameValues to a newly allocated EntityProto.
@@ -97,11 +121,10 @@ func nvToProto(defaultAppID string, key *Key, typeName string, nv []nameValue) (
 	if key.parent == nil {
 		e.EntityGroup = &pb.Path{}
 	} else {
-		e.EntityGroup = keyToProto(defaultAppID, key.root()).Path
+		e.EntityGroup = keyToProto(defaultAppID, key.Root()).Path
 	}
 	for _, x := range nv {
-		_, isBlob := x.value.Interface().([]byte)
-		if x.value.Kind() == reflect.Slice && !isBlob {
+		if x.value.Kind() == reflect.Slice && !isBlob(x.value.Type()) {
 			// Save each element of the field as a multiple-valued property.
 			for j := 0; j < x.value.Len(); j++ {
 				elem := x.value.Index(j)
@@ -113,7 +136,7 @@ func nvToProto(defaultAppID string, key *Key, typeName string, nv []nameValue) (
 				if errStr != "" {
 					return nil, fmt.Errorf(errMsg, x.name, typeName, errStr)
 				}
-				addProperty(e, property, elem)
+				addProperty(e, property, elem, x.noIndex)
 			}
 			continue
 		}
@@ -126,36 +149,106 @@ func nvToProto(defaultAppID string, key *Key, typeName string, nv []nameValue) (
 		if errStr != "" {
 			return nil, fmt.Errorf(errMsg, x.name, typeName, errStr)
 		}
-		addProperty(e, property, x.value)
+		addProperty(e, property, x.value, x.noIndex)
 	}
 	if len(e.Property) > maxIndexedProperties {
-		return nil, fmt.Errorf("datastore: too many indexed properties")
+		return nil, &ErrTooManyIndexedProperties{TypeName: typeName, Count: len(e.Property)}
 	}
 	return e, nil
 }
 
-// saveStruct converts an entity struct to a newly allocated EntityProto.
-func saveStruct(defaultAppID string, key *Key, sv reflect.Value) (*pb.EntityProto, error) {
-	nv := make([]nameValue, sv.NumField())
-	n, st := 0, sv.Type()
-	for i := 0; i < sv.NumField(); i++ {
-		name, value := st.Field(i).Name, sv.Field(i)
-		if unexported(name) || !value.IsValid() {
+// structNameValues appends the fields of the struct sv to nv, naming each
+// one prefix followed by its property name. A non-nil pointer to a nested
+// struct is flattened into "Field.SubField" names; a nil one is skipped.
+// The entries of a Map or map[string]interface{} field are flattened into
+// "Field.Name" names; nil entries are skipped.
+//
+// parents holds the nested struct pointers being flattened, to report a
+// cyclic value instead of recursing forever; it may be nil.
+func structNameValues(nv []nameValue, prefix string, sv reflect.Value, noIndex bool, parents map[uintptr]bool) ([]nameValue, error) {
+	codec, err := getStructCodec(sv.Type())
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range codec.fields {
+		value := sv.Field(f.index)
+		if !value.IsValid() {
 			continue
 		}
-		nv[n] = nameValue{name, value}
-		n++
+		if isNestedStruct(value.Type()) {
+			if value.IsNil() {
+				continue
+			}
+			p := value.Pointer()
+			if parents[p] {
+				return nil, fmt.Errorf("datastore: cyclic reference in field %q", prefix+f.name)
+			}
+			if parents == nil {
+				parents = make(map[uintptr]bool)
+			}
+			parents[p] = true
+			nv, err = structNameValues(nv, prefix+f.name+".", value.Elem(), noIndex || f.noIndex, parents)
+			if err != nil {
+				return nil, err
+			}
+			delete(parents, p)
+			continue
+		}
+		if isEntityMap(value.Type()) {
+			for _, k := range value.MapKeys() {
+				if v := value.MapIndex(k).Elem(); v.IsValid() {
+					nv = append(nv, nameValue{prefix + f.name + "." + k.String(), v, noIndex || f.noIndex})
+				}
+			}
+			continue
+		}
+		nv = append(nv, nameValue{prefix + f.name, value, noIndex || f.noIndex})
 	}
-	return nvToProto(defaultAppID, key, st.Name(), nv[:n])
+	return nv, nil
 }
 
-// saveMap converts an entity Map to a newly allocated EntityProto.
-func saveMap(defaultAppID string, key *Key, m Map) (*pb.EntityProto, error) {
+// saveStruct converts an entity struct to a newly allocated EntityProto.
+//
+// Fields are saved under the property name given by their struct tag, if any.
+// Unexported fields and fields tagged with "-" are skipped.
+func saveStruct(defaultAppID string, key *Key, sv reflect.Value) (*pb.EntityProto, error) {
+	nv, err := structNameValues(nil, "", sv, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	return nvToProto(defaultAppID, key, sv.Type().Name(), nv)
+}
+
+// mapNameValues returns the entries of the Map m as nameValues.
+func mapNameValues(m Map, noIndex bool) []nameValue {
 	nv := make([]nameValue, len(m))
 	n := 0
 	for k, v := range m {
-		nv[n] = nameValue{k, reflect.ValueOf(v)}
+		nv[n] = nameValue{k, reflect.ValueOf(v), noIndex}
 		n++
 	}
-	return nvToProto(defaultAppID, key, "datastore.Map", nv)
+	return nv
+}
+
+// saveMap converts an entity Map to a newly allocated EntityProto.
+func saveMap(defaultAppID string, key *Key, m Map) (*pb.EntityProto, error) {
+	return nvToProto(defaultAppID, key, "datastore.Map", mapNameValues(m, false))
+}
+
+// saveEntity converts src, a struct pointer or a Map, to a newly allocated
+// EntityProto. If noIndex is true, all properties are stored unindexed and
+// don't count towards the indexed properties limit.
+func saveEntity(defaultAppID string, key *Key, src interface{}, noIndex bool) (*pb.EntityProto, error) {
+	if m, ok := src.(Map); ok {
+		return nvToProto(defaultAppID, key, "datastore.Map", mapNameValues(m, noIndex))
+	}
+	sv, err := asStructValue(src)
+	if err != nil {
+		return nil, err
+	}
+	nv, err := structNameValues(nil, "", sv, noIndex, nil)
+	if err != nil {
+		return nil, err
+	}
+	return nvToProto(defaultAppID, key, sv.Type().Name(), nv)
 }
@@ -25,6 +25,11 @@ type transaction struct {
 	finished    bool
 }
 
+// Namespace returns the namespace of the context the transaction runs in.
+func (t *transaction) Namespace() string {
+	return contextNamespace(t.Context)
+}
+
 var errBadTransactionField = errors.New("datastore: Call parameter has an incompatible Transaction field")
 
 // setTransactionField performs the equivalent of "x.Transaction =
@@ -30,7 +30,10 @@ package log
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"appengine"
 	"appengine_internal"
@@ -64,6 +67,23 @@ type Query struct {
 
 	// The major version IDs whose logs should be retrieved.
 	Versions []string
+
+	// RequestIDs, if non-empty, restricts the results to the requests with
+	// these IDs.
+	RequestIDs []string
+
+	// MinLatency and MaxLatency, if non-zero, restrict the results to the
+	// requests whose latency, in microseconds, is at least MinLatency and at
+	// most MaxLatency. The logs service can't filter on latency, so the
+	// records out of range are still fetched, and skipped by Result.Next:
+	// a query that matches few requests may read many pages of logs.
+	MinLatency int64
+	MaxLatency int64
+
+	// Cancel, if non-nil, aborts a long scan when it is closed or receives a
+	// value. From then on Result.Next returns ErrCanceled without issuing
+	// further RPCs.
+	Cancel <-chan bool
 }
 
 // AppLog represents a single application-level log.
@@ -114,36 +134,109 @@ type Record struct {
 	AppLogs           []AppLog
 }
 
+// CombinedLogLine returns the request in the Apache combined log format:
+//
+//	IP - Nickname [StartTime] "Method Resource HTTPVersion" Status ResponseSize "Referrer" "UserAgent"
+//
+// It returns the Combined field if it is set, and otherwise formats the line
+// from the other fields. The start time is formatted in UTC, and empty
+// fields are replaced by "-".
+func (r *Record) CombinedLogLine() string {
+	if r.Combined != "" {
+		return r.Combined
+	}
+	size := "-"
+	if r.ResponseSize != 0 {
+		size = strconv.Itoa64(r.ResponseSize)
+	}
+	t := time.NanosecondsToUTC(r.StartTime * 1000)
+	return fmt.Sprintf(`%s - %s [%s] "%s %s %s" %d %s "%s" "%s"`,
+		orDash(r.IP), orDash(r.Nickname), t.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method, r.Resource, r.HTTPVersion, r.Status, size,
+		orDash(r.Referrer), orDash(r.UserAgent))
+}
+
+// orDash returns s, or "-" if s is empty.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
 // Result represents the result of a query.
 type Result struct {
 	logs        []*Record
 	context     appengine.Context
 	request     *log_proto.LogReadRequest
 	resultsSeen bool
+	cancel      <-chan bool
+	canceled    bool
+	minLatency  int64
+	maxLatency  int64
 }
 
 // Next returns the next log record,
 func (qr *Result) Next() (*Record, error) {
-	if len(qr.logs) > 0 {
-		lr := qr.logs[0]
-		qr.logs = qr.logs[1:]
-		return lr, nil
-	}
+	for {
+		if qr.isCanceled() {
+			return nil, ErrCanceled
+		}
 
-	if qr.request.Offset == nil && qr.resultsSeen {
-		return nil, Done
-	}
+		if len(qr.logs) > 0 {
+			lr := qr.logs[0]
+			qr.logs = qr.logs[1:]
+			if !qr.inLatencyRange(lr) {
+				continue
+			}
+			return lr, nil
+		}
 
-	if err := qr.run(); err != nil {
-		return nil, err
+		if qr.request.Offset == nil && qr.resultsSeen {
+			return nil, Done
+		}
+
+		if err := qr.run(); err != nil {
+			return nil, err
+		}
 	}
+	panic("unreachable")
+}
 
-	return qr.Next()
+// inLatencyRange reports whether the latency of the record lr is within the
+// query's MinLatency and MaxLatency.
+func (qr *Result) inLatencyRange(lr *Record) bool {
+	if qr.minLatency != 0 && lr.Latency < qr.minLatency {
+		return false
+	}
+	if qr.maxLatency != 0 && lr.Latency > qr.maxLatency {
+		return false
+	}
+	return true
 }
 
 // Done is returned when a query iteration has completed.
 var Done = errors.New("log: query has no more results")
 
+// ErrNoSuchRecord is returned by Get when no request has the given ID.
+var ErrNoSuchRecord = errors.New("log: no such record")
+
+// ErrCanceled is returned when a query iteration was aborted through
+// Query.Cancel.
+var ErrCanceled = errors.New("log: query was canceled")
+
+// isCanceled reports whether the query's cancel channel has fired.
+func (qr *Result) isCanceled() bool {
+	if !qr.canceled {
+		select {
+		case <-qr.cancel:
+			qr.canceled = true
+		default:
+		}
+	}
+	return qr.canceled
+}
+
 // protoToAppLogs takes as input an array of pointers to LogLines, the internal
 // Protocol Buffer representation of a single application-level log,
 // and converts it to an array of AppLogs, the external representation
@@ -229,8 +322,32 @@ func (params *Query) Run(c appengine.Context) *Result {
 	} else {
 		req.VersionId = params.Versions
 	}
+	if len(params.RequestIDs) != 0 {
+		req.RequestId = params.RequestIDs
+	}
+
+	return &Result{
+		context:    c,
+		request:    req,
+		cancel:     params.Cancel,
+		minLatency: params.MinLatency,
+		maxLatency: params.MaxLatency,
+	}
+}
 
-	return &Result{context: c, request: req}
+// Get returns the log record of the request with the given ID, including its
+// application logs. It returns ErrNoSuchRecord if there is no such request
+// in the logs of the app's current major version.
+func Get(c appengine.Context, requestID string) (*Record, error) {
+	q := &Query{AppLogs: true, RequestIDs: []string{requestID}}
+	record, err := q.Run(c).Next()
+	if err == Done {
+		return nil, ErrNoSuchRecord
+	}
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
 }
 
 // run takes the query Result produced by a call to Run and updates it with
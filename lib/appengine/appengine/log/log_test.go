@@ -0,0 +1,153 @@
+// Copyright 2011 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"fmt"
+	"http"
+	"testing"
+
+	"appengine_internal"
+	"goprotobuf.googlecode.com/hg/proto"
+
+	log_proto "appengine_internal/log"
+)
+
+// fakeContext is an appengine.Context that serves logservice Read calls with
+// one record per call, reporting an offset until pages records were returned.
+// The record of the i-th call has ID i and latency latencies[i], if set, and
+// is only returned if the request filters on no ID or on that one. It counts
+// the calls made.
+type fakeContext struct {
+	pages     int
+	latencies []int64
+	calls     int
+}
+
+func (c *fakeContext) Debugf(format string, args ...interface{})    {}
+func (c *fakeContext) Infof(format string, args ...interface{})     {}
+func (c *fakeContext) Warningf(format string, args ...interface{})  {}
+func (c *fakeContext) Errorf(format string, args ...interface{})    {}
+func (c *fakeContext) Criticalf(format string, args ...interface{}) {}
+func (c *fakeContext) AppID() string                                { return "app" }
+func (c *fakeContext) FullyQualifiedAppID() string                  { return "dev~app" }
+func (c *fakeContext) Request() interface{}                         { return http.Header{} }
+
+func (c *fakeContext) Call(service, method string, in, out interface{}, opts *appengine_internal.CallOptions) error {
+	c.calls++
+	res := out.(*log_proto.LogReadResponse)
+	rl := newRequestLog(fmt.Sprint(c.calls))
+	if c.calls <= len(c.latencies) {
+		rl.Latency = proto.Int64(c.latencies[c.calls-1])
+	}
+	if ids := in.(*log_proto.LogReadRequest).RequestId; len(ids) == 0 || ids[0] == *rl.RequestId {
+		res.Log = []*log_proto.RequestLog{rl}
+	}
+	if c.calls < c.pages {
+		res.Offset = &log_proto.LogOffset{RequestId: proto.String(fmt.Sprint(c.calls))}
+	}
+	return nil
+}
+
+// newRequestLog returns a RequestLog with all required fields set.
+func newRequestLog(requestID string) *log_proto.RequestLog {
+	return &log_proto.RequestLog{
+		AppId:        proto.String("dev~app"),
+		VersionId:    proto.String("1"),
+		RequestId:    proto.String(requestID),
+		Ip:           proto.String("127.0.0.1"),
+		StartTime:    proto.Int64(0),
+		EndTime:      proto.Int64(0),
+		Latency:      proto.Int64(0),
+		Mcycles:      proto.Int64(0),
+		Method:       proto.String("GET"),
+		Resource:     proto.String("/"),
+		HttpVersion:  proto.String("HTTP/1.1"),
+		Status:       proto.Int32(200),
+		ResponseSize: proto.Int64(0),
+		UrlMapEntry:  proto.String("/"),
+		Combined:     proto.String(""),
+	}
+}
+
+func TestCancel(t *testing.T) {
+	c := &fakeContext{pages: 1000}
+	cancel := make(chan bool)
+	results := (&Query{Versions: []string{"1"}, Cancel: cancel}).Run(c)
+	for i := 0; i < 2; i++ {
+		if _, err := results.Next(); err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+	}
+	close(cancel)
+	for i := 0; i < 2; i++ {
+		if _, err := results.Next(); err != ErrCanceled {
+			t.Errorf("Next after cancel: got %v, want ErrCanceled", err)
+		}
+	}
+	if c.calls != 2 {
+		t.Errorf("calls: got %d, want 2", c.calls)
+	}
+}
+
+func TestLatencyFilter(t *testing.T) {
+	latencies := []int64{50, 1500, 200, 9000, 1000, 3000}
+	c := &fakeContext{pages: len(latencies), latencies: latencies}
+	results := (&Query{Versions: []string{"1"}, MinLatency: 1000, MaxLatency: 5000}).Run(c)
+	var got []int64
+	for {
+		record, err := results.Next()
+		if err == Done {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		got = append(got, record.Latency)
+	}
+	if fmt.Sprint(got) != "[1500 1000 3000]" {
+		t.Errorf("latencies: got %v, want [1500 1000 3000]", got)
+	}
+	if c.calls != len(latencies) {
+		t.Errorf("calls: got %d, want %d", c.calls, len(latencies))
+	}
+}
+
+func TestCombinedLogLine(t *testing.T) {
+	r := &Record{
+		IP:           "192.0.2.1",
+		StartTime:    1321453800123456,
+		Method:       "GET",
+		Resource:     "/search?q=go",
+		HTTPVersion:  "HTTP/1.1",
+		Status:       200,
+		ResponseSize: 2326,
+		Referrer:     "http://example.com/",
+		UserAgent:    "Mozilla/5.0",
+	}
+	want := `192.0.2.1 - - [16/Nov/2011:14:30:00 +0000] "GET /search?q=go HTTP/1.1" 200 2326 "http://example.com/" "Mozilla/5.0"`
+	if got := r.CombinedLogLine(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	r.Combined = "combined line"
+	if got := r.CombinedLogLine(); got != r.Combined {
+		t.Errorf("got %q, want the Combined field", got)
+	}
+}
+
+func TestGet(t *testing.T) {
+	record, err := Get(new(fakeContext), "1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if record.RequestID != "1" {
+		t.Errorf("request ID: got %q, want %q", record.RequestID, "1")
+	}
+
+	if _, err := Get(new(fakeContext), "2"); err != ErrNoSuchRecord {
+		t.Errorf("missing request: got %v, want ErrNoSuchRecord", err)
+	}
+}
@@ -16,6 +16,14 @@ Example:
 	if err := mail.Send(c, msg); err != nil {
 		c.Errorf("Alas, my user, the email failed to sendeth: %v", err)
 	}
+
+Email received by the application is posted to /_ah/mail/<address>, and
+ParseMessage reads it:
+
+	func incomingMail(w http.ResponseWriter, r *http.Request) {
+		msg, err := mail.ParseMessage(r)
+		// ...
+	}
 */
 package mail
 
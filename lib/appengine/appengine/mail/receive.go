@@ -0,0 +1,138 @@
+// Copyright 2011 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package mail
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"http"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+// ParseMessage parses an email message received by the application, which
+// App Engine delivers as the body of a POST request to
+// /_ah/mail/<address>.
+//
+// The Sender, ReplyTo, To, Cc and Subject fields are set from the message
+// headers. For a multipart message, the first text/plain part that is not
+// an attachment is the Body, and parts with a file name are the Attachments;
+// nested multipart parts, such as multipart/alternative, are searched too.
+// Base64 encoded parts are decoded; other encodings, such as
+// quoted-printable, are returned as they are.
+func ParseMessage(r *http.Request) (*Message, error) {
+	tp := textproto.NewReader(bufio.NewReader(r.Body))
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("mail: error reading message header: %v", err)
+	}
+	msg := &Message{
+		Sender:  header.Get("From"),
+		ReplyTo: header.Get("Reply-To"),
+		To:      splitAddresses(header.Get("To")),
+		Cc:      splitAddresses(header.Get("Cc")),
+		Subject: header.Get("Subject"),
+	}
+	if err := parsePart(msg, header, tp.R); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// parsePart adds the body of a message or MIME part with the given header
+// to msg, as its Body or as an attachment, or parses its parts if it is a
+// multipart body.
+func parsePart(msg *Message, header textproto.MIMEHeader, body io.Reader) error {
+	ctype, params := mime.ParseMediaType(header.Get("Content-Type"))
+	if strings.HasPrefix(ctype, "multipart/") {
+		boundary := params["boundary"]
+		if boundary == "" {
+			return errors.New("mail: did not find MIME multipart boundary")
+		}
+		mreader := multipart.NewReader(body, boundary)
+		for {
+			part, err := mreader.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("mail: error reading MIME part: %v", err)
+			}
+			if err := parsePart(msg, part.Header, part); err != nil {
+				return err
+			}
+		}
+		panic("unreachable")
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("mail: error reading message body: %v", err)
+	}
+	if strings.ToLower(header.Get("Content-Transfer-Encoding")) == "base64" {
+		if data, err = decodeBase64(data); err != nil {
+			return fmt.Errorf("mail: error decoding base64 body: %v", err)
+		}
+	}
+	_, dparams := mime.ParseMediaType(header.Get("Content-Disposition"))
+	name := dparams["filename"]
+	if name == "" {
+		name = params["name"]
+	}
+	switch {
+	case name != "":
+		msg.Attachments = append(msg.Attachments, Attachment{Name: name, Data: data})
+	case msg.Body == "" && (ctype == "" || ctype == "text/plain"):
+		msg.Body = string(data)
+	}
+	return nil
+}
+
+// decodeBase64 decodes base64 data split into lines.
+func decodeBase64(data []byte) ([]byte, error) {
+	s := strings.Map(func(r int) int {
+		if r == '\r' || r == '\n' || r == ' ' || r == '\t' {
+			return -1
+		}
+		return r
+	}, string(data))
+	return base64.StdEncoding.DecodeString(s)
+}
+
+// splitAddresses splits a header holding a comma-separated list of
+// addresses. Commas inside quoted names or angle brackets don't split.
+func splitAddresses(s string) []string {
+	var addrs []string
+	quoted, bracketed := false, false
+	start := 0
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) {
+			switch c := s[i]; {
+			case c == '"' && !bracketed:
+				quoted = !quoted
+				continue
+			case c == '<' && !quoted:
+				bracketed = true
+				continue
+			case c == '>' && !quoted:
+				bracketed = false
+				continue
+			case c != ',' || quoted || bracketed:
+				continue
+			}
+		}
+		if addr := strings.TrimSpace(s[start:i]); addr != "" {
+			addrs = append(addrs, addr)
+		}
+		start = i + 1
+	}
+	return addrs
+}
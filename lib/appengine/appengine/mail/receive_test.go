@@ -0,0 +1,104 @@
+// Copyright 2011 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package mail
+
+import (
+	"http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newMailRequest(t *testing.T, raw string) *http.Request {
+	raw = strings.Replace(raw, "\n", "\r\n", -1)
+	req, err := http.NewRequest("POST", "http://localhost/_ah/mail/app@example.com", strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	return req
+}
+
+func TestParseTextMessage(t *testing.T) {
+	req := newMailRequest(t, `From: Romeo <romeo@montague.com>
+To: "Capulet, Juliet" <juliet@capulet.org>, nurse@capulet.org
+Subject: See you tonight
+Content-Type: text/plain; charset=UTF-8
+
+Don't forget our plans.
+Hark, 'til later.
+`)
+	msg, err := ParseMessage(req)
+	if err != nil {
+		t.Fatalf("ParseMessage: %v", err)
+	}
+	if msg.Sender != "Romeo <romeo@montague.com>" {
+		t.Errorf("Sender: got %q", msg.Sender)
+	}
+	wantTo := []string{`"Capulet, Juliet" <juliet@capulet.org>`, "nurse@capulet.org"}
+	if !reflect.DeepEqual(msg.To, wantTo) {
+		t.Errorf("To: got %q, want %q", msg.To, wantTo)
+	}
+	if msg.Subject != "See you tonight" {
+		t.Errorf("Subject: got %q", msg.Subject)
+	}
+	if want := "Don't forget our plans.\r\nHark, 'til later.\r\n"; msg.Body != want {
+		t.Errorf("Body: got %q, want %q", msg.Body, want)
+	}
+	if len(msg.Attachments) != 0 {
+		t.Errorf("Attachments: got %d, want none", len(msg.Attachments))
+	}
+}
+
+func TestParseMultipartMessage(t *testing.T) {
+	req := newMailRequest(t, `From: romeo@montague.com
+To: juliet@capulet.org
+Cc: friar@verona.org
+Subject: Poem
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: multipart/alternative; boundary="inner"
+
+--inner
+Content-Type: text/plain
+
+But soft, what light.
+--inner
+Content-Type: text/html
+
+<p>But soft, what light.</p>
+--inner--
+
+--outer
+Content-Type: text/plain; name="poem.txt"
+Content-Disposition: attachment; filename="poem.txt"
+Content-Transfer-Encoding: base64
+
+SXQgaXMgdGhlIGVhc3Qs
+IGFuZCBKdWxpZXQgaXMgdGhlIHN1bi4=
+--outer--
+`)
+	msg, err := ParseMessage(req)
+	if err != nil {
+		t.Fatalf("ParseMessage: %v", err)
+	}
+	if len(msg.To) != 1 || msg.To[0] != "juliet@capulet.org" {
+		t.Errorf("To: got %q", msg.To)
+	}
+	if len(msg.Cc) != 1 || msg.Cc[0] != "friar@verona.org" {
+		t.Errorf("Cc: got %q", msg.Cc)
+	}
+	if msg.Body != "But soft, what light." {
+		t.Errorf("Body: got %q", msg.Body)
+	}
+	if len(msg.Attachments) != 1 {
+		t.Fatalf("Attachments: got %d, want 1", len(msg.Attachments))
+	}
+	att := msg.Attachments[0]
+	if att.Name != "poem.txt" || string(att.Data) != "It is the east, and Juliet is the sun." {
+		t.Errorf("attachment: got %q with %q", att.Name, att.Data)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2011 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package appengine
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+
+	"appengine_internal"
+)
+
+// validNamespace matches valid namespace names.
+var validNamespace = regexp.MustCompile(`^[0-9A-Za-z._-]{0,100}$`)
+
+// Namespace returns a replacement context that operates within the given
+// namespace. Data stored in one namespace is isolated from the data of other
+// namespaces: datastore keys and queries, memcache items and the tasks added
+// with the returned context are all scoped to it.
+//
+// A namespace is at most 100 characters long, made of ASCII letters, digits,
+// dots, dashes and underscores. The empty string is the default namespace.
+func Namespace(c Context, namespace string) (Context, error) {
+	if !validNamespace.MatchString(namespace) {
+		return nil, fmt.Errorf("appengine: namespace %q does not match /%s/", namespace, validNamespace)
+	}
+	return &namespacedContext{c, namespace}, nil
+}
+
+// namespacedContext is a Context scoped to a namespace.
+//
+// Service packages that need the namespace, such as datastore to build keys,
+// get it through the Namespace method.
+type namespacedContext struct {
+	Context
+	namespace string
+}
+
+func (c *namespacedContext) Namespace() string {
+	return c.namespace
+}
+
+// Call sets the NameSpace field of the request in, if it has one and it is
+// not set yet, before making the call. Datastore requests are left alone:
+// the datastore package sets the namespace of each key and query itself.
+func (c *namespacedContext) Call(service, method string, in, out interface{}, opts *appengine_internal.CallOptions) error {
+	if service != "datastore_v3" {
+		v := reflect.ValueOf(in)
+		if v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Struct {
+			f := v.Elem().FieldByName("NameSpace")
+			if f.IsValid() && f.Type() == reflect.TypeOf((*string)(nil)) && f.IsNil() {
+				ns := c.namespace
+				f.Set(reflect.ValueOf(&ns))
+			}
+		}
+	}
+	return c.Context.Call(service, method, in, out, opts)
+}
@@ -0,0 +1,80 @@
+// Copyright 2011 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package appengine
+
+import (
+	"strings"
+	"testing"
+
+	"appengine_internal"
+	"goprotobuf.googlecode.com/hg/proto"
+
+	memcache_proto "appengine_internal/memcache"
+)
+
+// fakeContext is a Context that records the requests of its calls.
+type fakeContext struct {
+	requests []interface{}
+}
+
+func (c *fakeContext) Debugf(format string, args ...interface{})    {}
+func (c *fakeContext) Infof(format string, args ...interface{})     {}
+func (c *fakeContext) Warningf(format string, args ...interface{})  {}
+func (c *fakeContext) Errorf(format string, args ...interface{})    {}
+func (c *fakeContext) Criticalf(format string, args ...interface{}) {}
+func (c *fakeContext) AppID() string                                { return "app" }
+func (c *fakeContext) FullyQualifiedAppID() string                  { return "dev~app" }
+func (c *fakeContext) Request() interface{}                         { return nil }
+
+func (c *fakeContext) Call(service, method string, in, out interface{}, opts *appengine_internal.CallOptions) error {
+	c.requests = append(c.requests, in)
+	return nil
+}
+
+func TestNamespaceValidation(t *testing.T) {
+	for _, ns := range []string{"", "a", "Team-1.prod_2", strings.Repeat("x", 100)} {
+		if _, err := Namespace(new(fakeContext), ns); err != nil {
+			t.Errorf("%q: unexpected error: %v", ns, err)
+		}
+	}
+	for _, ns := range []string{"a b", "a/b", "é", strings.Repeat("x", 101)} {
+		if _, err := Namespace(new(fakeContext), ns); err == nil {
+			t.Errorf("%q: expected an error", ns)
+		}
+	}
+}
+
+func TestNamespaceMemcache(t *testing.T) {
+	c := new(fakeContext)
+	nc, err := Namespace(c, "ns")
+	if err != nil {
+		t.Fatalf("Namespace: %v", err)
+	}
+	if got := nc.(interface {
+		Namespace() string
+	}).Namespace(); got != "ns" {
+		t.Errorf("Namespace(): got %q, want %q", got, "ns")
+	}
+
+	req := &memcache_proto.MemcacheGetRequest{Key: [][]byte{[]byte("k")}}
+	nc.Call("memcache", "Get", req, &memcache_proto.MemcacheGetResponse{}, nil)
+	if got := proto.GetString(req.NameSpace); got != "ns" {
+		t.Errorf("memcache request namespace: got %q, want %q", got, "ns")
+	}
+
+	// A namespace set by the caller is kept.
+	req = &memcache_proto.MemcacheGetRequest{NameSpace: proto.String("other")}
+	nc.Call("memcache", "Get", req, &memcache_proto.MemcacheGetResponse{}, nil)
+	if got := proto.GetString(req.NameSpace); got != "other" {
+		t.Errorf("memcache request namespace: got %q, want %q", got, "other")
+	}
+
+	// Requests made with the original context are in the default namespace.
+	req = &memcache_proto.MemcacheGetRequest{}
+	c.Call("memcache", "Get", req, &memcache_proto.MemcacheGetResponse{}, nil)
+	if req.NameSpace != nil {
+		t.Errorf("memcache request namespace: got %q, want it unset", *req.NameSpace)
+	}
+}
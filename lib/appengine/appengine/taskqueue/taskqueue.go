@@ -19,8 +19,12 @@ package taskqueue
 // TODO: Bulk task adding/deleting, queue management.
 
 import (
+	"crypto/sha1"
+	"errors"
 	"fmt"
 	"http"
+	"regexp"
+	"strings"
 	"time"
 	"url"
 
@@ -45,7 +49,7 @@ type Task struct {
 
 	// Additional HTTP headers to pass at the task's execution time.
 	// To schedule the task to be run with an alternate app version
-	// or backend, set the "Host" header.
+	// or backend, set the "Host" header, or call Target.
 	Header http.Header
 
 	// Method is the HTTP method for the task ("GET", "POST", etc.),
@@ -59,8 +63,72 @@ type Task struct {
 
 	// Delay is how far into the future this task should execute, in microseconds.
 	Delay int64
+
+	// queueName is the queue a task returned by LeaseTasks was leased from.
+	queueName string
+}
+
+// Target sets the "Host" header of the task so that it runs on the given
+// version of a module, such as a backend. An empty version targets the
+// module's default version.
+func (t *Task) Target(module, version string) error {
+	if module == "" {
+		return errors.New("taskqueue: empty target module")
+	}
+	host := module
+	if version != "" {
+		host = version + "." + module
+	}
+	if t.Header == nil {
+		t.Header = make(http.Header)
+	}
+	t.Header.Set("Host", host)
+	return nil
+}
+
+// ErrTaskAlreadyAdded is the error returned by Add when a task with the same
+// name was already added, or was added and then deleted or run recently.
+var ErrTaskAlreadyAdded = errors.New("taskqueue: task has already been added")
+
+// maxTaskNameLen is the maximum length of a task name.
+const maxTaskNameLen = 500
+
+// validTaskName matches the names App Engine accepts for tasks.
+var validTaskName = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+
+// DedupeName sets the task's Name from a key identifying the work it does,
+// so that adding several tasks with the same key only adds the first one:
+// Add returns ErrTaskAlreadyAdded for the others.
+//
+// Keys that are valid task names are used as they are. Other keys have their
+// illegal characters replaced by underscores, are truncated and get the
+// SHA-1 hash of the key appended, so that different keys give different
+// names.
+func (t *Task) DedupeName(key string) {
+	if len(key) <= maxTaskNameLen && validTaskName.MatchString(key) {
+		t.Name = key
+		return
+	}
+	h := sha1.New()
+	h.Write([]byte(key))
+	sum := fmt.Sprintf("%x", h.Sum())
+	name := strings.Map(func(r int) int {
+		if r == '_' || r == '-' || '0' <= r && r <= '9' ||
+			'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}, key)
+	if max := maxTaskNameLen - len(sum) - 1; len(name) > max {
+		name = name[:max]
+	}
+	t.Name = name + "-" + sum
 }
 
+// namespaceHeader is the header that tells App Engine the namespace to run
+// a task in.
+const namespaceHeader = "X-AppEngine-Current-Namespace"
+
 // NewPOSTTask creates a Task that will POST to a path with the given form data.
 func NewPOSTTask(path string, params url.Values) *Task {
 	h := make(http.Header)
@@ -104,7 +172,19 @@ func Add(c appengine.Context, task *Task, queueName string) (*Task, error) {
 			return nil, fmt.Errorf("taskqueue: bad method %q", method)
 		}
 		req.Url = []byte(task.Path)
-		for k, vs := range task.Header {
+		header := task.Header
+		if nc, ok := c.(interface {
+			Namespace() string
+		}); ok && nc.Namespace() != "" && header.Get(namespaceHeader) == "" {
+			// Run the task in the namespace of the context that added it.
+			// Tasks run in the default namespace without the header.
+			header = make(http.Header)
+			for k, vs := range task.Header {
+				header[k] = vs
+			}
+			header.Set(namespaceHeader, nc.Namespace())
+		}
+		for k, vs := range header {
 			for _, v := range vs {
 				req.Header = append(req.Header, &taskqueue_proto.TaskQueueAddRequest_Header{
 					Key:   []byte(k),
@@ -118,6 +198,13 @@ func Add(c appengine.Context, task *Task, queueName string) (*Task, error) {
 	}
 	res := &taskqueue_proto.TaskQueueAddResponse{}
 	if err := c.Call("taskqueue", "Add", req, res, nil); err != nil {
+		if ae, ok := err.(*appengine_internal.APIError); ok {
+			switch taskqueue_proto.TaskQueueServiceError_ErrorCode(ae.Code) {
+			case taskqueue_proto.TaskQueueServiceError_TASK_ALREADY_EXISTS,
+				taskqueue_proto.TaskQueueServiceError_TOMBSTONED_TASK:
+				return nil, ErrTaskAlreadyAdded
+			}
+		}
 		return nil, err
 	}
 	resultTask := *task
@@ -166,14 +253,24 @@ func LeaseTasks(c appengine.Context, maxTasks int, queueName string, leaseTime i
 	for i, t := range res.Task {
 		// TODO: Handle eta_usec, retry_count.
 		tasks[i] = &Task{
-			Payload: t.Body,
-			Name:    string(t.TaskName),
-			Method:  "PULL",
+			Payload:   t.Body,
+			Name:      string(t.TaskName),
+			Method:    "PULL",
+			queueName: queueName,
 		}
 	}
 	return tasks, nil
 }
 
+// Ack acknowledges the completion of a task returned by LeaseTasks by
+// deleting it from the queue it was leased from.
+func (t *Task) Ack(c appengine.Context) error {
+	if t.queueName == "" {
+		return errors.New("taskqueue: Ack called on a task that was not leased")
+	}
+	return Delete(c, t, t.queueName)
+}
+
 // Purge removes all tasks from a queue.
 func Purge(c appengine.Context, queueName string) error {
 	req := &taskqueue_proto.TaskQueuePurgeQueueRequest{
@@ -183,6 +280,32 @@ func Purge(c appengine.Context, queueName string) error {
 	return c.Call("taskqueue", "PurgeQueue", req, res, nil)
 }
 
+// PurgeAndCount removes all tasks from a queue, like Purge, and returns the
+// number of tasks the queue held beforehand.
+//
+// The PurgeQueue call doesn't report how many tasks it removed, so the count
+// is fetched from the queue statistics before purging. Tasks added or leased
+// in between the two calls, and the delay with which the statistics are
+// updated, make the count approximate.
+func PurgeAndCount(c appengine.Context, queueName string) (int, error) {
+	req := &taskqueue_proto.TaskQueueFetchQueueStatsRequest{
+		QueueName:   [][]byte{[]byte(queueName)},
+		MaxNumTasks: proto.Int32(0),
+	}
+	res := &taskqueue_proto.TaskQueueFetchQueueStatsResponse{}
+	if err := c.Call("taskqueue", "FetchQueueStats", req, res, nil); err != nil {
+		return 0, err
+	}
+	if len(res.Queuestats) != 1 {
+		return 0, fmt.Errorf("taskqueue: expected stats for 1 queue, got %d", len(res.Queuestats))
+	}
+	n := int(proto.GetInt32(res.Queuestats[0].NumTasks))
+	if err := Purge(c, queueName); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
 func init() {
 	appengine_internal.RegisterErrorCodeMap("taskqueue", taskqueue_proto.TaskQueueServiceError_ErrorCode_name)
 }
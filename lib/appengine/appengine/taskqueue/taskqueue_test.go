@@ -0,0 +1,244 @@
+// Copyright 2011 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package taskqueue
+
+import (
+	"http"
+	"strings"
+	"testing"
+
+	"appengine"
+	"appengine_internal"
+	"goprotobuf.googlecode.com/hg/proto"
+
+	taskqueue_proto "appengine_internal/taskqueue"
+)
+
+// fakeContext is an appengine.Context that records the taskqueue methods
+// called and their requests, reports numTasks tasks from FetchQueueStats and
+// leases the leased tasks from QueryAndOwnTasks. Adding a task whose name
+// was already added fails with addCode.
+type fakeContext struct {
+	numTasks int32
+	added    map[string]bool
+	addCode  taskqueue_proto.TaskQueueServiceError_ErrorCode
+	leased   []string
+	methods  []string
+	requests []interface{}
+}
+
+func (c *fakeContext) Debugf(format string, args ...interface{})    {}
+func (c *fakeContext) Infof(format string, args ...interface{})     {}
+func (c *fakeContext) Warningf(format string, args ...interface{})  {}
+func (c *fakeContext) Errorf(format string, args ...interface{})    {}
+func (c *fakeContext) Criticalf(format string, args ...interface{}) {}
+func (c *fakeContext) AppID() string                                { return "app" }
+func (c *fakeContext) FullyQualifiedAppID() string                  { return "dev~app" }
+func (c *fakeContext) Request() interface{}                         { return nil }
+
+func (c *fakeContext) Call(service, method string, in, out interface{}, opts *appengine_internal.CallOptions) error {
+	c.methods = append(c.methods, method)
+	c.requests = append(c.requests, in)
+	switch res := out.(type) {
+	case *taskqueue_proto.TaskQueueAddResponse:
+		name := string(in.(*taskqueue_proto.TaskQueueAddRequest).TaskName)
+		if name != "" && c.added[name] {
+			return &appengine_internal.APIError{Service: service, Code: int32(c.addCode)}
+		}
+		if c.added == nil {
+			c.added = make(map[string]bool)
+		}
+		c.added[name] = true
+	case *taskqueue_proto.TaskQueueFetchQueueStatsResponse:
+		res.Queuestats = []*taskqueue_proto.TaskQueueFetchQueueStatsResponse_QueueStats{
+			&taskqueue_proto.TaskQueueFetchQueueStatsResponse_QueueStats{
+				NumTasks:      proto.Int32(c.numTasks),
+				OldestEtaUsec: proto.Int64(0),
+			},
+		}
+	case *taskqueue_proto.TaskQueueQueryAndOwnTasksResponse:
+		for _, name := range c.leased {
+			res.Task = append(res.Task, &taskqueue_proto.TaskQueueQueryAndOwnTasksResponse_Task{
+				TaskName: []byte(name),
+				EtaUsec:  proto.Int64(0),
+			})
+		}
+	case *taskqueue_proto.TaskQueueDeleteResponse:
+		req := in.(*taskqueue_proto.TaskQueueDeleteRequest)
+		res.Result = make([]taskqueue_proto.TaskQueueServiceError_ErrorCode, len(req.TaskName))
+	}
+	return nil
+}
+
+func TestPurgeAndCount(t *testing.T) {
+	c := &fakeContext{numTasks: 7}
+	n, err := PurgeAndCount(c, "q")
+	if err != nil {
+		t.Fatalf("PurgeAndCount: %v", err)
+	}
+	if n != 7 {
+		t.Errorf("count: got %d, want 7", n)
+	}
+	if len(c.methods) != 2 || c.methods[0] != "FetchQueueStats" || c.methods[1] != "PurgeQueue" {
+		t.Errorf("methods: got %v, want [FetchQueueStats PurgeQueue]", c.methods)
+	}
+}
+
+func TestAck(t *testing.T) {
+	c := &fakeContext{leased: []string{"t1"}}
+	tasks, err := LeaseTasks(c, 1, "pull", 60)
+	if err != nil {
+		t.Fatalf("LeaseTasks: %v", err)
+	}
+	if len(tasks) != 1 {
+		t.Fatalf("got %d tasks, want 1", len(tasks))
+	}
+	if err := tasks[0].Ack(c); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+	req, ok := c.requests[len(c.requests)-1].(*taskqueue_proto.TaskQueueDeleteRequest)
+	if !ok {
+		t.Fatalf("last call: got method %s, want Delete", c.methods[len(c.methods)-1])
+	}
+	if string(req.QueueName) != "pull" || len(req.TaskName) != 1 || string(req.TaskName[0]) != "t1" {
+		t.Errorf("Delete request: got queue %q and tasks %q", req.QueueName, req.TaskName)
+	}
+
+	if err := (&Task{Name: "t2"}).Ack(c); err == nil {
+		t.Errorf("expected an error acking a task that was not leased")
+	}
+}
+
+func TestTarget(t *testing.T) {
+	task := &Task{Path: "/work"}
+	if err := task.Target("worker", "v2"); err != nil {
+		t.Fatalf("Target: %v", err)
+	}
+	if got := task.Header.Get("Host"); got != "v2.worker" {
+		t.Errorf("Host: got %q, want %q", got, "v2.worker")
+	}
+	if err := task.Target("", "v2"); err == nil {
+		t.Errorf("expected an error for an empty module")
+	}
+
+	c := new(fakeContext)
+	if _, err := Add(c, task, ""); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	req := c.requests[0].(*taskqueue_proto.TaskQueueAddRequest)
+	if host := addRequestHeader(req, "Host"); host != "v2.worker" {
+		t.Errorf("Add request Host header: got %q, want %q", host, "v2.worker")
+	}
+
+	task = new(Task)
+	task.Target("worker", "")
+	if got := task.Header.Get("Host"); got != "worker" {
+		t.Errorf("Host: got %q, want %q", got, "worker")
+	}
+}
+
+// addRequestHeader returns the value of a header of an Add request.
+func addRequestHeader(req *taskqueue_proto.TaskQueueAddRequest, key string) string {
+	for _, h := range req.Header {
+		if string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func TestAddNamespace(t *testing.T) {
+	tests := []struct {
+		namespace string
+		header    string
+		want      string
+	}{
+		{"tenant", "", "tenant"},
+		{"tenant", "other", "other"},
+		{"", "", ""},
+	}
+	for _, test := range tests {
+		c := new(fakeContext)
+		nc, err := appengine.Namespace(c, test.namespace)
+		if err != nil {
+			t.Fatalf("appengine.Namespace: %v", err)
+		}
+		task := &Task{Path: "/work", Header: make(http.Header)}
+		if test.header != "" {
+			task.Header.Set(namespaceHeader, test.header)
+		}
+		if _, err := Add(nc, task, ""); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		req := c.requests[0].(*taskqueue_proto.TaskQueueAddRequest)
+		if got := addRequestHeader(req, namespaceHeader); got != test.want {
+			t.Errorf("namespace %q, header %q: got %q, want %q", test.namespace, test.header, got, test.want)
+		}
+	}
+
+	// Tasks added without a namespaced context don't get the header.
+	c := new(fakeContext)
+	if _, err := Add(c, &Task{Path: "/work"}, ""); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	req := c.requests[0].(*taskqueue_proto.TaskQueueAddRequest)
+	if got := addRequestHeader(req, namespaceHeader); got != "" {
+		t.Errorf("default context: got header %q", got)
+	}
+}
+
+func TestDedupeName(t *testing.T) {
+	long := strings.Repeat("a", 600)
+	tests := []struct {
+		key, want string
+	}{
+		{"user-42_welcome", "user-42_welcome"},
+		{"user 42/welcome", "user_42_welcome-"},
+		{"", "-"},
+		{long, strings.Repeat("a", 459) + "-"},
+	}
+	for _, test := range tests {
+		task := new(Task)
+		task.DedupeName(test.key)
+		if !strings.HasPrefix(task.Name, test.want) {
+			t.Errorf("DedupeName(%.20q): got %q, want prefix %q", test.key, task.Name, test.want)
+		}
+		if len(task.Name) > maxTaskNameLen || !validTaskName.MatchString(task.Name) {
+			t.Errorf("DedupeName(%.20q): invalid name %q", test.key, task.Name)
+		}
+	}
+
+	a, b := new(Task), new(Task)
+	a.DedupeName("user 42")
+	b.DedupeName("user/42")
+	if a.Name == b.Name {
+		t.Errorf("keys %q and %q have the same name %q", "user 42", "user/42", a.Name)
+	}
+}
+
+func TestAddDuplicate(t *testing.T) {
+	codes := []taskqueue_proto.TaskQueueServiceError_ErrorCode{
+		taskqueue_proto.TaskQueueServiceError_TASK_ALREADY_EXISTS,
+		taskqueue_proto.TaskQueueServiceError_TOMBSTONED_TASK,
+	}
+	for _, code := range codes {
+		c := &fakeContext{addCode: code}
+		task := NewPOSTTask("/work", nil)
+		task.DedupeName("order 7")
+		if _, err := Add(c, task, ""); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		if _, err := Add(c, task, ""); err != ErrTaskAlreadyAdded {
+			t.Errorf("%v: got %v, want ErrTaskAlreadyAdded", code, err)
+		}
+	}
+
+	c := &fakeContext{addCode: taskqueue_proto.TaskQueueServiceError_INTERNAL_ERROR}
+	task := &Task{Name: "t"}
+	Add(c, task, "")
+	if _, err := Add(c, task, ""); err == nil || err == ErrTaskAlreadyAdded {
+		t.Errorf("got %v, want the service error", err)
+	}
+}
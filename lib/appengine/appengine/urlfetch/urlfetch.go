@@ -13,6 +13,8 @@ import (
 	"io"
 	"io/ioutil"
 	"strconv"
+	"strings"
+	"time"
 
 	"appengine"
 	"appengine_internal"
@@ -29,6 +31,18 @@ type Transport struct {
 	Context                       appengine.Context
 	DeadlineSeconds               float64 // zero means App Engine's default
 	AllowInvalidServerCertificate bool
+
+	// RetryCount is how many times a Fetch RPC that failed with a transient
+	// service error, such as DEADLINE_EXCEEDED, is retried. Zero means no
+	// retries. HTTP-level errors such as 5xx responses are not retried.
+	RetryCount int
+
+	// MaxResponseBytes, if positive, makes RoundTrip fail with
+	// ErrTruncatedBody when the response body is longer than this many bytes
+	// or was truncated by App Engine's proxy, instead of returning a partial
+	// body. The proxy itself truncates response bodies larger than 32
+	// megabytes, so larger limits only make truncation a hard error.
+	MaxResponseBytes int64
 }
 
 // Verify statically that *Transport implements http.RoundTripper.
@@ -53,7 +67,8 @@ type bodyReader struct {
 // response's Body if the body has been truncated by App Engine's proxy.
 //
 // ErrTruncatedBody is only returned once. Subsequent reads will
-// return os.EOF.
+// return os.EOF. It is returned by RoundTrip instead if the Transport
+// has a MaxResponseBytes limit.
 var ErrTruncatedBody = errors.New("urlfetch: truncated body")
 
 func statusCodeToText(code int) string {
@@ -85,6 +100,9 @@ func (br *bodyReader) Close() error {
 	return nil
 }
 
+// maxRequestBytes is the largest request body App Engine's proxy accepts.
+const maxRequestBytes = 5 << 20
+
 // A map of the URL Fetch-accepted methods that take a request body.
 var methodAcceptsRequestBody = map[string]bool{
 	"POST": true,
@@ -127,14 +145,27 @@ func (t *Transport) RoundTrip(req *http.Request) (res *http.Response, err error)
 		}
 	}
 	if methodAcceptsRequestBody[req.Method] {
-		freq.Payload, err = ioutil.ReadAll(req.Body)
+		// The Fetch RPC needs the whole body, but reading one byte past the
+		// limit is enough to reject it before the RPC does.
+		freq.Payload, err = ioutil.ReadAll(io.LimitReader(req.Body, maxRequestBytes+1))
 		if err != nil {
 			return nil, err
 		}
+		if len(freq.Payload) > maxRequestBytes {
+			return nil, fmt.Errorf("urlfetch: request body exceeds the %d byte limit", maxRequestBytes)
+		}
 	}
 
 	fres := &pb.URLFetchResponse{}
-	if err := t.Context.Call("urlfetch", "Fetch", freq, fres, opts); err != nil {
+	for attempt := 0; ; attempt++ {
+		err = t.Context.Call("urlfetch", "Fetch", freq, fres, opts)
+		if err == nil || attempt >= t.RetryCount || !isTransientError(err) {
+			break
+		}
+		fres.Reset()
+		time.Sleep(int64(attempt+1) * retryDelay)
+	}
+	if err != nil {
 		return nil, err
 	}
 
@@ -148,30 +179,67 @@ func (t *Transport) RoundTrip(req *http.Request) (res *http.Response, err error)
 	res.ProtoMajor = 1
 	res.ProtoMinor = 1
 	res.Proto = "HTTP/1.1"
-	res.Close = true
 
+	// Headers are added one value at a time, so that repeated headers such
+	// as Set-Cookie keep all their values.
 	for _, h := range fres.Header {
 		hkey := http.CanonicalHeaderKey(*h.Key)
 		hval := *h.Value
-		if hkey == "Content-Length" {
-			// Will get filled in below for all but HEAD requests.
-			if req.Method == "HEAD" {
-				res.ContentLength, _ = strconv.Atoi64(hval)
-			}
-			continue
+		if hkey == "Content-Length" && req.Method == "HEAD" {
+			res.ContentLength, _ = strconv.Atoi64(hval)
 		}
 		res.Header.Add(hkey, hval)
 	}
 
+	// ContentLength is the length of the body actually returned, which is
+	// shorter than the length declared by the server in the Content-Length
+	// header if the proxy truncated the body.
 	if req.Method != "HEAD" {
 		res.ContentLength = int64(len(fres.Content))
 	}
 
+	// urlfetch never reuses connections, so unless the server asked to keep
+	// the connection alive, report it as closed.
+	res.Close = !hasToken(res.Header.Get("Connection"), "keep-alive")
+
 	truncated := proto.GetBool(fres.ContentWasTruncated)
+	if t.MaxResponseBytes > 0 && (truncated || int64(len(fres.Content)) > t.MaxResponseBytes) {
+		return nil, ErrTruncatedBody
+	}
 	res.Body = &bodyReader{content: fres.Content, truncated: truncated}
 	return
 }
 
+// hasToken returns whether the comma-separated header value v contains
+// token, ignoring case.
+func hasToken(v, token string) bool {
+	for _, t := range strings.Split(v, ",") {
+		if strings.ToLower(strings.TrimSpace(t)) == token {
+			return true
+		}
+	}
+	return false
+}
+
+// retryDelay is the base delay, in nanoseconds, between retries of a failed
+// Fetch RPC. The n-th retry waits n times this delay.
+var retryDelay int64 = 100e6
+
+// transientErrors are the urlfetch service error codes worth retrying.
+var transientErrors = map[pb.URLFetchServiceError_ErrorCode]bool{
+	pb.URLFetchServiceError_DEADLINE_EXCEEDED: true,
+}
+
+// isTransientError returns whether err is a urlfetch service error that may
+// succeed if the request is retried.
+func isTransientError(err error) bool {
+	ae, ok := err.(*appengine_internal.APIError)
+	if !ok || ae.Service != "urlfetch" {
+		return false
+	}
+	return transientErrors[pb.URLFetchServiceError_ErrorCode(ae.Code)]
+}
+
 func init() {
 	appengine_internal.RegisterErrorCodeMap("urlfetch", pb.URLFetchServiceError_ErrorCode_name)
 }
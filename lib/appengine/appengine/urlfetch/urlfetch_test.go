@@ -0,0 +1,214 @@
+// Copyright 2011 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package urlfetch
+
+import (
+	"bytes"
+	"http"
+	"strings"
+	"testing"
+
+	"appengine_internal"
+	"goprotobuf.googlecode.com/hg/proto"
+
+	pb "appengine_internal/urlfetch"
+)
+
+// fakeContext is an appengine.Context that serves urlfetch calls by
+// returning the queued errors in order, then a 200 response with the
+// given headers and content, "ok" by default, marked as truncated if
+// truncated is set.
+type fakeContext struct {
+	errs      []error
+	header    http.Header
+	content   string
+	truncated bool
+	calls     int
+}
+
+func (c *fakeContext) Debugf(format string, args ...interface{})    {}
+func (c *fakeContext) Infof(format string, args ...interface{})     {}
+func (c *fakeContext) Warningf(format string, args ...interface{})  {}
+func (c *fakeContext) Errorf(format string, args ...interface{})    {}
+func (c *fakeContext) Criticalf(format string, args ...interface{}) {}
+func (c *fakeContext) AppID() string                                { return "app" }
+func (c *fakeContext) FullyQualifiedAppID() string                  { return "dev~app" }
+func (c *fakeContext) Request() interface{}                         { return nil }
+
+func (c *fakeContext) Call(service, method string, in, out interface{}, opts *appengine_internal.CallOptions) error {
+	c.calls++
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		return err
+	}
+	res := out.(*pb.URLFetchResponse)
+	res.StatusCode = proto.Int32(200)
+	res.Content = []byte("ok")
+	if c.content != "" {
+		res.Content = []byte(c.content)
+	}
+	res.ContentWasTruncated = proto.Bool(c.truncated)
+	for k, vs := range c.header {
+		for _, v := range vs {
+			res.Header = append(res.Header, &pb.URLFetchResponse_Header{
+				Key:   proto.String(k),
+				Value: proto.String(v),
+			})
+		}
+	}
+	return nil
+}
+
+func apiError(code pb.URLFetchServiceError_ErrorCode) error {
+	return &appengine_internal.APIError{Service: "urlfetch", Code: int32(code)}
+}
+
+func TestRetryTransientError(t *testing.T) {
+	defer func(d int64) { retryDelay = d }(retryDelay)
+	retryDelay = 0
+	c := &fakeContext{errs: []error{apiError(pb.URLFetchServiceError_DEADLINE_EXCEEDED)}}
+	tr := &Transport{Context: c, RetryCount: 2}
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	if res.StatusCode != 200 {
+		t.Errorf("StatusCode: got %d, want 200", res.StatusCode)
+	}
+	if c.calls != 2 {
+		t.Errorf("calls: got %d, want 2", c.calls)
+	}
+}
+
+func TestNoRetryPermanentError(t *testing.T) {
+	defer func(d int64) { retryDelay = d }(retryDelay)
+	retryDelay = 0
+	c := &fakeContext{errs: []error{apiError(pb.URLFetchServiceError_INVALID_URL)}}
+	tr := &Transport{Context: c, RetryCount: 2}
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	if _, err := tr.RoundTrip(req); err == nil {
+		t.Errorf("expected an error")
+	}
+	if c.calls != 1 {
+		t.Errorf("calls: got %d, want 1", c.calls)
+	}
+}
+
+func TestResponseClose(t *testing.T) {
+	tests := []struct {
+		connection string
+		close      bool
+	}{
+		{"", true},
+		{"close", true},
+		{"keep-alive", false},
+		{"Keep-Alive", false},
+		{"foo, keep-alive", false},
+	}
+	for _, tt := range tests {
+		c := &fakeContext{header: http.Header{}}
+		if tt.connection != "" {
+			c.header.Set("Connection", tt.connection)
+		}
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		res, err := (&Transport{Context: c}).RoundTrip(req)
+		if err != nil {
+			t.Fatalf("RoundTrip: %v", err)
+		}
+		if res.Close != tt.close {
+			t.Errorf("Connection %q: got Close %v, want %v", tt.connection, res.Close, tt.close)
+		}
+	}
+}
+
+func TestMaxResponseBytes(t *testing.T) {
+	tests := []struct {
+		max       int64
+		truncated bool
+		fail      bool
+	}{
+		{0, false, false},
+		{0, true, false},
+		{2, false, false},
+		{1, false, true},
+		{100, true, true},
+	}
+	for _, tt := range tests {
+		c := &fakeContext{truncated: tt.truncated}
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		_, err := (&Transport{Context: c, MaxResponseBytes: tt.max}).RoundTrip(req)
+		if tt.fail && err != ErrTruncatedBody {
+			t.Errorf("max %d, truncated %v: got %v, want ErrTruncatedBody", tt.max, tt.truncated, err)
+		}
+		if !tt.fail && err != nil {
+			t.Errorf("max %d, truncated %v: unexpected error %v", tt.max, tt.truncated, err)
+		}
+	}
+}
+
+func TestRequestTooLarge(t *testing.T) {
+	c := new(fakeContext)
+	body := bytes.NewBuffer(make([]byte, maxRequestBytes+1))
+	req, _ := http.NewRequest("POST", "http://example.com/", body)
+	_, err := (&Transport{Context: c}).RoundTrip(req)
+	if err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("expected a request size error, got %v", err)
+	}
+	if c.calls != 0 {
+		t.Errorf("calls: got %d, want 0", c.calls)
+	}
+}
+
+func TestContentLength(t *testing.T) {
+	tests := []struct {
+		method        string
+		content       string
+		declared      string
+		truncated     bool
+		contentLength int64
+	}{
+		{"HEAD", "", "1234", false, 1234},
+		{"GET", "hello", "5", false, 5},
+		{"GET", "hel", "5", true, 3},
+	}
+	for _, tt := range tests {
+		c := &fakeContext{
+			header:    http.Header{"Content-Length": {tt.declared}},
+			content:   tt.content,
+			truncated: tt.truncated,
+		}
+		req, _ := http.NewRequest(tt.method, "http://example.com/", nil)
+		res, err := (&Transport{Context: c}).RoundTrip(req)
+		if err != nil {
+			t.Fatalf("RoundTrip: %v", err)
+		}
+		if res.ContentLength != tt.contentLength {
+			t.Errorf("%s %q: got ContentLength %d, want %d", tt.method, tt.content, res.ContentLength, tt.contentLength)
+		}
+		if got := res.Header.Get("Content-Length"); got != tt.declared {
+			t.Errorf("%s %q: got Content-Length header %q, want %q", tt.method, tt.content, got, tt.declared)
+		}
+	}
+}
+
+func TestRepeatedHeaders(t *testing.T) {
+	c := &fakeContext{header: http.Header{
+		"set-cookie":     {"a=1", "b=2"},
+		"Content-Length": {"2"},
+	}}
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	res, err := (&Transport{Context: c}).RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	if got := res.Header["Set-Cookie"]; len(got) != 2 || got[0] != "a=1" || got[1] != "b=2" {
+		t.Errorf("Set-Cookie: got %q, want [a=1 b=2]", got)
+	}
+	if got := res.Header.Get("Content-Length"); got != "2" {
+		t.Errorf("Content-Length: got %q, want 2", got)
+	}
+}